@@ -0,0 +1,47 @@
+package widget
+
+import (
+	"http"
+
+	"appengine"
+)
+
+// adminPage is an entry on the admin index.
+type adminPage struct {
+	Path  string
+	Title string
+}
+
+var adminPages = []*adminPage{
+	{"/admin/migrations", "Migrations"},
+	{"/admin/cron", "Scheduled Jobs"},
+	{"/admin/tags", "Tags"},
+	{"/admin/reviews", "Reviews"},
+	{"/task/metrics", "Metrics"},
+}
+
+type adminData struct {
+	CSS    string
+	Header string
+	Page   []*adminPage
+}
+
+func adminIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/" {
+		fourOhFour(w, r)
+		return
+	}
+
+	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
+
+	data := adminData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+		Page:   adminPages,
+	}
+
+	if err := renderPage(w, loc, "admin.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
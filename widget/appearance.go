@@ -0,0 +1,359 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"appengine"
+)
+
+// Owners choose how their embedded widget looks.  The default follows the
+// viewer's light or dark preference.  The choice is stored on the widget, and
+// can be overridden for a single embed with query parameters on the widget
+// URL, e.g. /widget/show/{ID}/widget.js?theme=dark.
+
+// A theme is a named, built in ColorScheme.  If it has Dark colors, they are
+// used instead when the viewer prefers a dark color scheme.
+type theme struct {
+	Name   string
+	Colors ColorScheme
+	Dark   *ColorScheme
+}
+
+// customTheme is the name of the theme made from a widget's ThemeColors.
+const customTheme = "custom"
+
+var darkColors = ColorScheme{
+	Main: Pallete{"#B3DDBC", "#8BDD9D", "#3C6E47", "#2A4D32", "#1B2B1F"},
+	Good: Pallete{"#AFD4D8", "#89D1D8", "#356368", "#24464A", "#172C2F"},
+	Warn: Pallete{"#E6CFBA", "#E6B991", "#7A5A3C", "#4D3926", "#2E2218"},
+	Bad:  Pallete{"#E6BEBA", "#E69991", "#7A403A", "#4D2925", "#2E1917"},
+}
+
+// The first theme is the default.
+var themes = []*theme{
+	{"auto", gowidgetColors, &darkColors},
+	{"light", gowidgetColors, nil},
+	{"dark", darkColors, nil},
+	{"high-contrast", ColorScheme{
+		Main: Pallete{"#000000", "#1A1A1A", "#000000", "#FFFF00", "#FFFFFF"},
+		Good: Pallete{"#FFFFFF", "#FFFFFF", "#000000", "#333333", "#000000"},
+		Warn: Pallete{"#000000", "#000000", "#000000", "#FFD700", "#FFD700"},
+		Bad:  Pallete{"#FFFFFF", "#FFFFFF", "#000000", "#B00000", "#B00000"},
+	}, nil},
+	{"monochrome", ColorScheme{
+		Main: Pallete{"#333333", "#444444", "#666666", "#CCCCCC", "#EEEEEE"},
+		Good: Pallete{"#222222", "#333333", "#555555", "#BBBBBB", "#DDDDDD"},
+		Warn: Pallete{"#444444", "#555555", "#777777", "#CCCCCC", "#E6E6E6"},
+		Bad:  Pallete{"#111111", "#222222", "#444444", "#AAAAAA", "#D5D5D5"},
+	}, nil},
+}
+
+// The built in themes have to be readable.
+func init() {
+	for _, t := range themes {
+		if err := t.Colors.CheckContrast(); err != nil {
+			panic("theme " + t.Name + ": " + err.String())
+		}
+	}
+}
+
+func findTheme(name string) *theme {
+	for _, t := range themes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// paletteNames are the names of the palettes in a ColorScheme, in the order
+// they are written in ThemeColors.
+var paletteNames = []string{"main", "good", "warn", "bad"}
+
+func (c *ColorScheme) palettes() []*Pallete {
+	return []*Pallete{&c.Main, &c.Good, &c.Warn, &c.Bad}
+}
+
+func (p *Pallete) colors() []*string {
+	return []*string{&p.Text, &p.Text2, &p.Border, &p.Light, &p.Background}
+}
+
+// String formats a ColorScheme the way ParseColorScheme reads it: a line per
+// palette, with its name and its five colors.
+func (c *ColorScheme) String() string {
+	buf := bytes.NewBuffer(nil)
+	for i, p := range c.palettes() {
+		fmt.Fprintf(buf, "%s:", paletteNames[i])
+		for _, color := range p.colors() {
+			fmt.Fprintf(buf, " %s", *color)
+		}
+		fmt.Fprintln(buf)
+	}
+	return buf.String()
+}
+
+// validColor returns true if color is an HTML color of the form #RRGGBB.
+func validColor(color string) bool {
+	if len(color) != 7 || color[0] != '#' {
+		return false
+	}
+	for _, c := range color[1:] {
+		if strings.IndexRune("0123456789abcdefABCDEF", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// minContrast is the lowest contrast ratio WCAG AA allows for normal text.
+const minContrast = 4.5
+
+// luminance returns the relative luminance of an HTML color of the form
+// #RRGGBB, as defined by WCAG.
+func luminance(color string) float64 {
+	var lum float64
+	for i, weight := range []float64{0.2126, 0.7152, 0.0722} {
+		c, _ := strconv.Btoui64(color[1+2*i:3+2*i], 16)
+		v := float64(c) / 255
+		if v <= 0.03928 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		lum += weight * v
+	}
+	return lum
+}
+
+// contrast returns the WCAG contrast ratio between two colors, from 1 to 21.
+func contrast(a, b string) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// CheckContrast returns an error if the text of any palette isn't readable
+// against its background under WCAG AA.
+func (c *ColorScheme) CheckContrast() os.Error {
+	for i, p := range c.palettes() {
+		for _, text := range []string{p.Text, p.Text2} {
+			if ratio := contrast(text, p.Background); ratio < minContrast {
+				return fmt.Errorf("palette %s: %s on %s has contrast %.2f, needs %.1f",
+					paletteNames[i], text, p.Background, ratio, minContrast)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseColorScheme reads a ColorScheme in the format written by String.  The
+// colors of each palette are its text, secondary text, border, light and
+// background colors.
+func ParseColorScheme(text string) (*ColorScheme, os.Error) {
+	colors := new(ColorScheme)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n", -1) {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("missing palette name in %q", line)
+		}
+		name := strings.ToLower(strings.TrimSpace(line[:colon]))
+		values := strings.Fields(line[colon+1:])
+
+		var palette *Pallete
+		for i, p := range colors.palettes() {
+			if paletteNames[i] == name {
+				palette = p
+			}
+		}
+		if palette == nil {
+			return nil, fmt.Errorf("unknown palette %q", name)
+		}
+		if len(values) != 5 {
+			return nil, fmt.Errorf("palette %s needs 5 colors, not %d", name, len(values))
+		}
+		for i, color := range palette.colors() {
+			if !validColor(values[i]) {
+				return nil, fmt.Errorf("palette %s: invalid color %q", name, values[i])
+			}
+			*color = strings.ToUpper(values[i])
+		}
+		seen[name] = true
+	}
+	for _, name := range paletteNames {
+		if !seen[name] {
+			return nil, fmt.Errorf("missing palette %s", name)
+		}
+	}
+	return colors, nil
+}
+
+// currentTheme returns the theme the widget is shown with.  Unknown themes
+// fall back to the default.
+func (w *Widget) currentTheme() *theme {
+	name := w.Theme
+	if len(w.override.Theme) > 0 {
+		name = w.override.Theme
+	}
+
+	if name == customTheme && len(w.ThemeColors) > 0 {
+		if colors, err := ParseColorScheme(w.ThemeColors); err == nil {
+			return &theme{Name: customTheme + ":" + Hashf("%s", w.ThemeColors), Colors: *colors}
+		}
+	}
+	if t := findTheme(name); t != nil {
+		return t
+	}
+	return themes[0]
+}
+
+// ThemeOptions returns the themes to choose from, for a select.
+func (w *Widget) ThemeOptions() (opts []*categoryOption) {
+	for _, t := range themes {
+		opts = append(opts, &categoryOption{t.Name, t.Name == w.Theme})
+	}
+	return append(opts, &categoryOption{customTheme, customTheme == w.Theme})
+}
+
+// CustomColors returns the colors of the widget's custom theme, or the
+// default colors to start from if it doesn't have one.
+func (w *Widget) CustomColors() string {
+	if len(w.ThemeColors) > 0 {
+		return w.ThemeColors
+	}
+	return gowidgetColors.String()
+}
+
+// embedOverride holds the appearance given in the query parameters of an
+// embed, which takes the place of the widget's own.
+type embedOverride struct {
+	Theme    string
+	Layout   string
+	Sections []string
+}
+
+// Override sets the appearance for this showing of the widget from the query
+// parameters of r, and its language from the request.  Parameters which
+// aren't valid are ignored.
+func (w *Widget) Override(r *http.Request) {
+	w.locale = localeFor(r)
+	w.override.Theme = r.FormValue("theme")
+	if layout := r.FormValue("layout"); validLayout(layout) {
+		w.override.Layout = layout
+	}
+	if list := r.FormValue("sections"); len(list) > 0 {
+		w.override.Sections, _ = ParseSections(list)
+	}
+}
+
+// widgetStatic holds the rendered widget CSS for each theme.
+var widgetStatic = make(map[string]string)
+
+// writeWidgetCSS writes the CSS of the widget in a theme, from
+// templates/widget: layout.css, then colors.css for its colors and again for
+// its dark colors.  Like the widget, it must not contain any single quotes, as
+// it is written out by document.write.  It is only rendered once for each
+// theme, except on the development server, where the templates may change.
+func writeWidgetCSS(w io.Writer, t *theme) {
+	if _, ok := widgetStatic[t.Name]; !ok || devAppServer {
+		buf := bytes.NewBuffer(nil)
+		fmt.Fprintf(buf, "<style type=\"text/css\">\n")
+		err := executeTemplate(buf, locales[0], "widget/layout.css", t.Colors)
+		if err == nil {
+			fmt.Fprintf(buf, "\n")
+			err = executeTemplate(buf, locales[0], "widget/colors.css", t.Colors)
+		}
+		if err == nil && t.Dark != nil {
+			fmt.Fprintf(buf, "\n@media (prefers-color-scheme: dark)\n{\n")
+			err = executeTemplate(buf, locales[0], "widget/colors.css", *t.Dark)
+			fmt.Fprintf(buf, "}\n")
+		}
+		fmt.Fprintf(buf, "</style>\n")
+		if err != nil {
+			buf.Truncate(0)
+			fmt.Fprintf(buf, "<b>Error</b>: %s<br/>", err)
+		}
+		widgetStatic[t.Name] = buf.String()
+	}
+	fmt.Fprint(w, widgetStatic[t.Name])
+}
+
+// updateAppearance saves the owner's choices for how the widget looks.
+func updateAppearance(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 3 {
+		http.Error(w, "/widget/appearance/{widget} - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	widgethash := path[2]
+	if len(widgethash) != 32 {
+		http.Error(w, "Invalid widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+	if !widget.CanEdit() {
+		http.Error(w, "Only the owner can change this widget", http.StatusForbidden)
+		return
+	}
+
+	switch name := r.FormValue("theme"); {
+	case name == customTheme:
+		colors, err := ParseColorScheme(r.FormValue("colors"))
+		if err != nil {
+			http.Error(w, "Invalid custom theme: "+err.String(), http.StatusBadRequest)
+			return
+		}
+		if err := colors.CheckContrast(); err != nil {
+			http.Error(w, "Unreadable custom theme: "+err.String(), http.StatusBadRequest)
+			return
+		}
+		widget.Theme = customTheme
+		widget.ThemeColors = colors.String()
+	case findTheme(name) != nil:
+		widget.Theme = name
+	default:
+		http.Error(w, "Unknown theme: "+name, http.StatusBadRequest)
+		return
+	}
+
+	layout := r.FormValue("layout")
+	if !validLayout(layout) {
+		http.Error(w, "Unknown layout: "+layout, http.StatusBadRequest)
+		return
+	}
+	sections, err := ParseSections(r.FormValue("sections"))
+	if err != nil {
+		http.Error(w, "Invalid sections: "+err.String(), http.StatusBadRequest)
+		return
+	}
+	widget.Layout = layout
+	widget.Sections = sections
+
+	if err := widget.Commit(); err != nil {
+		http.Error(w, "Error comitting: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/widget/list", http.StatusFound)
+}
@@ -0,0 +1,93 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"strings"
+	"template"
+
+	"appengine"
+)
+
+var badgeTemplate = template.MustParse(`` +
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{Width}" height="20">
+	<rect width="{LabelWidth}" height="20" fill="#555"/>
+	<rect x="{LabelWidth}" width="{ValueWidth}" height="20" fill="{Color}"/>
+	<g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">
+		<text x="{LabelX}" y="14">{Label|html}</text>
+		<text x="{ValueX}" y="14">{Value|html}</text>
+	</g>
+</svg>
+`, nil)
+
+type badgeData struct {
+	Label, Value, Color    string
+	LabelWidth, ValueWidth int
+}
+
+// Text widths are estimated, since the font isn't known in advance.
+func textWidth(s string) int {
+	return 7*len(s) + 12
+}
+
+func (b *badgeData) Width() int  { return b.LabelWidth + b.ValueWidth }
+func (b *badgeData) LabelX() int { return b.LabelWidth / 2 }
+func (b *badgeData) ValueX() int { return b.LabelWidth + b.ValueWidth/2 }
+
+// scoreColor returns the color used to show the given score.
+func scoreColor(colors ColorScheme, score int) string {
+	switch {
+	case score >= 4:
+		return colors.Main.Text
+	case score >= 2:
+		return colors.Warn.Text
+	}
+	return colors.Bad.Text
+}
+
+func showBadge(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 3 {
+		http.Error(w, "/widget/badge/{widget}.svg - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	widgethash := path[2]
+	if !strings.HasSuffix(widgethash, ".svg") {
+		http.Error(w, "Badges are only available as .svg", http.StatusNotFound)
+		return
+	}
+	widgethash = strings.ToUpper(widgethash[:len(widgethash)-len(".svg")])
+	if len(widgethash) != 32 {
+		http.Error(w, "Invalid widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusNotFound)
+		return
+	}
+
+	badge := &badgeData{
+		Label: "go-widget",
+		Value: "unavailable",
+		Color: gowidgetColors.Warn.Text,
+	}
+	if !widget.Unavailable() {
+		score := widget.Score()
+		badge.Value = fmt.Sprintf("%d/5", score)
+		badge.Color = scoreColor(gowidgetColors, score)
+	}
+	badge.LabelWidth = textWidth(badge.Label)
+	badge.ValueWidth = textWidth(badge.Value)
+
+	setCacheHeaders(w, cachePublic, !widget.Unavailable() && !widget.Stale())
+	if notModified(w, r, Hashf("badge|%s|%s", badge.Value, badge.Color)) {
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	badgeTemplate.Execute(w, badge)
+}
@@ -0,0 +1,182 @@
+package widget
+
+import (
+	"http"
+	"os"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// A BrokenReport is a "won't build" report.  Reports are stored as the Broken
+// kind, keyed like the other countables, with the details the reporter gave
+// and the owner's resolution.  Only open reports made since the last commit
+// count against the score.
+type BrokenReport struct {
+	ctx appengine.Context
+	key *datastore.Key
+
+	Widget *datastore.Key
+	Hash   string
+	Time   datastore.Time
+
+	Reason    string
+	GoVersion string
+	Platform  string // e.g. linux/amd64
+
+	Status   string
+	Resolved datastore.Time
+}
+
+// Report statuses
+const (
+	brokenOpen      = "open"
+	brokenResolved  = "resolved"
+	brokenDuplicate = "duplicate"
+	brokenInvalid   = "invalid"
+)
+
+var brokenStatuses = []string{brokenOpen, brokenResolved, brokenDuplicate, brokenInvalid}
+
+func validBrokenStatus(status string) bool {
+	for _, s := range brokenStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func NewBrokenReport(ctx appengine.Context, widgetid, hash string) *BrokenReport {
+	c := NewCountable(ctx, "Broken", widgetid, hash)
+	return &BrokenReport{
+		ctx:    ctx,
+		key:    c.key,
+		Widget: c.Widget,
+		Hash:   c.Hash,
+		Time:   c.Time,
+		Status: brokenOpen,
+	}
+}
+
+func (b *BrokenReport) Commit() (err os.Error) {
+	b.key, err = datastore.Put(b.ctx, b.key, b)
+	return
+}
+
+func (b *BrokenReport) Date() string {
+	return timestr(b.Time)
+}
+
+func (b *BrokenReport) KeyID() string {
+	return b.key.Encode()
+}
+
+func (b *BrokenReport) Open() bool {
+	return b.Status == brokenOpen
+}
+
+// StatusOptions returns the statuses an owner can choose from, for a select.
+func (b *BrokenReport) StatusOptions() (opts []*categoryOption) {
+	for _, s := range brokenStatuses {
+		opts = append(opts, &categoryOption{s, s == b.Status})
+	}
+	return
+}
+
+// LoadBrokenReports returns the most recent broken reports for a widget,
+// newest first.
+func LoadBrokenReports(ctx appengine.Context, widgetid string, limit int) (reports []*BrokenReport, err os.Error) {
+	parent := datastore.NewKey("Widget", strings.ToUpper(widgetid), 0, nil)
+
+	query := datastore.NewQuery("Broken")
+	query.Filter("Widget =", parent)
+	query.Order("-Time")
+	query.Limit(limit)
+
+	var k []*datastore.Key
+	k, err = query.GetAll(ctx, &reports)
+	for i, b := range reports {
+		b.ctx = ctx
+		b.key = k[i]
+	}
+	return
+}
+
+// countOpenBroken returns the number of open reports made since head.
+func countOpenBroken(ctx appengine.Context, widget *datastore.Key, head datastore.Time) (int, os.Error) {
+	query := datastore.NewQuery("Broken")
+	query.Filter("Widget =", widget)
+	query.Filter("Status =", brokenOpen)
+	query.Filter("Time >", head)
+	return query.Count(ctx)
+}
+
+// resolveBroken changes the status of a broken report.
+func resolveBroken(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	key, err := datastore.DecodeKey(r.FormValue("report"))
+	if err != nil || key.Kind() != "Broken" || key.Parent() == nil {
+		http.Error(w, "Invalid report", http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, key.Parent().StringID())
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+key.Parent().StringID(), http.StatusBadRequest)
+		return
+	}
+	if !widget.CanEdit() {
+		http.Error(w, "Only the owner can change this widget", http.StatusForbidden)
+		return
+	}
+
+	status := r.FormValue("status")
+	if !validBrokenStatus(status) {
+		http.Error(w, "Unknown status: "+status, http.StatusBadRequest)
+		return
+	}
+
+	report := &BrokenReport{ctx: ctx, key: key}
+	if err := datastore.Get(ctx, key, report); err != nil {
+		http.Error(w, "Unknown report", http.StatusBadRequest)
+		return
+	}
+
+	report.Status = status
+	report.Resolved = 0
+	if status != brokenOpen {
+		report.Resolved = now()
+	}
+	if err := report.Commit(); err != nil {
+		http.Error(w, "Error comitting: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := InvalidateStats(ctx, widget.ID); err != nil {
+		ctx.Warningf("Broken: invalidate %s: %s", widget.ID, err)
+	}
+	refreshWidget(w, r, widget.ID)
+
+	http.Redirect(w, r, "/p/"+widget.ID+"#broken", http.StatusFound)
+}
+
+// upgradeBrokenReport opens broken reports which were made before reports had
+// a status.
+func upgradeBrokenReport(ctx appengine.Context, key *datastore.Key, props datastore.Map, dryRun bool) (bool, os.Error) {
+	if status, _ := props["Status"].(string); len(status) > 0 {
+		return false, nil
+	}
+
+	report := &BrokenReport{ctx: ctx, key: key, Status: brokenOpen}
+	report.Widget, _ = props["Widget"].(*datastore.Key)
+	report.Hash, _ = props["Hash"].(string)
+	report.Time, _ = props["Time"].(datastore.Time)
+	if dryRun {
+		ctx.Infof("Broken %s: opened", key.StringID())
+		return true, nil
+	}
+	return true, report.Commit()
+}
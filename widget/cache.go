@@ -0,0 +1,75 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"strings"
+
+	"appengine"
+)
+
+// Embeds, badges and the leader board are fetched on every view of the pages
+// they're on, so they're cached briefly by browsers and proxies, and
+// revalidated with ETags after that.  stale-while-revalidate lets a cached
+// copy be shown while it is revalidated in the background.
+
+// Cache lifetimes, in seconds
+const (
+	cacheMaxAge               = 60
+	cacheStaleWhileRevalidate = 600
+)
+
+// Who a response can be cached for
+const (
+	cachePublic  = "public"
+	cachePrivate = "private" // e.g. it shows whether the viewer has rated it
+)
+
+// setCacheHeaders sets the Cache-Control header of a response.  Responses
+// which aren't fresh, e.g. because the stats are stale, aren't cached at all.
+func setCacheHeaders(w http.ResponseWriter, scope string, fresh bool) {
+	if !fresh {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d, stale-while-revalidate=%d",
+		scope, cacheMaxAge, cacheStaleWhileRevalidate))
+}
+
+// varyLanguage marks a response as depending on the viewer's language, for
+// responses shown in the locale from localeFor.
+func varyLanguage(w http.ResponseWriter) {
+	w.Header().Set("Vary", "Accept-Language")
+}
+
+// notModified sets the ETag of a response.  If the request's If-None-Match
+// already has it, it writes a 304 Not Modified and returns true, and the
+// response should not be written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",", -1) {
+		if tag = strings.TrimSpace(tag); tag == etag || tag == "W/"+etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ETag returns a tag for a kind of rendering of the widget, which changes
+// whenever anything it shows does: the stats, which are versioned by
+// statsVersion, the times since the last build and commit, the widget's
+// settings, appearance and language, and whether the viewer has rated it.
+// The app version is included so that a new release doesn't keep serving old
+// markup.
+func (w *Widget) ETag(kind string) string {
+	if !w.populated {
+		w.populate()
+	}
+	return Hashf("%s|%s|v%d|%#v|%v|%v|%v|%s|%s|%#v|%s|%s|%s|%s|%s|%s|%v|%s|%s|%s|%#v",
+		kind, appengine.VersionID(w.ctx), statsVersion, w.stats, w.stale, w.Unavailable(), w.rated,
+		w.CompileElapsed(), w.CheckinElapsed(), w.override, w.loc().Name,
+		w.Name, w.HomeURL, w.SourceURL, w.BugURL, w.Summary, w.EmbedSummary,
+		w.Theme, w.ThemeColors, w.Layout, w.Sections)
+}
@@ -0,0 +1,304 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/taskqueue"
+)
+
+// A cronJob is run periodically, as scheduled in cron.yaml.  Jobs are run a
+// batch at a time: Batch is given the cursor returned by the previous batch
+// (empty for the first) and returns the cursor for the next, or an empty
+// cursor if the job is finished.
+type cronJob struct {
+	Name        string
+	Description string
+	Batch       func(ctx appengine.Context, cursor string) (next string, processed int, err os.Error)
+}
+
+var cronJobs = []*cronJob{
+	{"rescore", "Recompute cached scores and ratings", cronRescore},
+	{"expire", "Roll up and expire old builds and commits, and old webhook deliveries", cronExpire},
+	{"digest", "Send daily and weekly digest emails to owners", cronDigest},
+	{"snapshot", "Record a leader board snapshot", cronSnapshot},
+	{"tags", "Recount the projects with each tag", cronTags},
+}
+
+func findCronJob(name string) *cronJob {
+	for _, job := range cronJobs {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// A CronRun records one run of a cron job, for the run history.
+type CronRun struct {
+	key *datastore.Key
+
+	Job       string
+	Status    string
+	Message   string
+	Batches   int64
+	Processed int64
+
+	Started  datastore.Time
+	Updated  datastore.Time
+	Finished datastore.Time
+}
+
+const (
+	cronRunning = "running"
+	cronOK      = "ok"
+	cronFailed  = "failed"
+)
+
+func (run *CronRun) Commit(ctx appengine.Context) (err os.Error) {
+	run.Updated = now()
+	run.key, err = datastore.Put(ctx, run.key, run)
+	return
+}
+
+func (run *CronRun) StartDate() string {
+	return timestr(run.Started)
+}
+
+func (run *CronRun) Elapsed() string {
+	end := run.Finished
+	if end == 0 {
+		end = run.Updated
+	}
+	return fmt.Sprintf("%.1fs", float64(end-run.Started)/1e6)
+}
+
+func LoadCronRuns(ctx appengine.Context, job string, limit int) (runs []*CronRun, err os.Error) {
+	query := datastore.NewQuery("CronRun")
+	query.Filter("Job =", job)
+	query.Order("-Started")
+	query.Limit(limit)
+
+	var k []*datastore.Key
+	k, err = query.GetAll(ctx, &runs)
+	for i, run := range runs {
+		run.key = k[i]
+	}
+
+	return
+}
+
+// taskCron runs one batch of a cron job.  Cron starts a job with a GET of
+// /task/cron/{job}, and the following batches are chained through the task
+// queue with the run and cursor as parameters.
+func taskCron(w http.ResponseWriter, r *http.Request) {
+	var err os.Error
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 3 {
+		http.Error(w, "/task/cron/{job} - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	job := findCronJob(path[2])
+	if job == nil {
+		http.Error(w, "Unknown job: "+path[2], http.StatusNotFound)
+		return
+	}
+
+	run := new(CronRun)
+	if id := r.FormValue("run"); len(id) > 0 {
+		if run.key, err = datastore.DecodeKey(id); err != nil {
+			http.Error(w, "Invalid run: "+id, http.StatusBadRequest)
+			return
+		}
+		if err = datastore.Get(ctx, run.key, run); err != nil {
+			http.Error(w, "Run: "+err.String(), http.StatusInternalServerError)
+			return
+		}
+		if run.Status != cronRunning {
+			return
+		}
+	} else {
+		run.key = datastore.NewIncompleteKey("CronRun", nil)
+		run.Job = job.Name
+		run.Status = cronRunning
+		run.Started = now()
+	}
+
+	next, processed, err := job.Batch(ctx, r.FormValue("cursor"))
+	run.Batches++
+	run.Processed += int64(processed)
+	switch {
+	case err != nil:
+		ctx.Errorf("Cron %s: %s", job.Name, err)
+		run.Status = cronFailed
+		run.Message = err.String()
+		run.Finished = now()
+	case len(next) == 0:
+		run.Status = cronOK
+		run.Finished = now()
+	}
+
+	if err := run.Commit(ctx); err != nil {
+		http.Error(w, "Commit: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	if run.Status == cronRunning {
+		task := taskqueue.NewPOSTTask("/task/cron/"+job.Name, http.Values{
+			"run":    {run.key.Encode()},
+			"cursor": {next},
+		})
+		if _, err := taskqueue.Add(ctx, task, "default"); err != nil {
+			run.Status = cronFailed
+			run.Message = "Queue: " + err.String()
+			run.Finished = now()
+			run.Commit(ctx)
+			http.Error(w, run.Message, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(r.FormValue("redirect")) > 0 {
+		http.Redirect(w, r, "/admin/cron", http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "%s: batch %d, %d processed, %s\n", job.Name, run.Batches, run.Processed, run.Status)
+}
+
+// batchWidgets calls fn for up to limit widgets, starting at cursor.  It
+// returns the cursor to continue from, which is empty once every widget has
+// been seen.
+func batchWidgets(ctx appengine.Context, cursor string, limit int, fn func(w *Widget) os.Error) (next string, processed int, err os.Error) {
+	query := datastore.NewQuery("Widget")
+	if len(cursor) > 0 {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", 0, err
+		}
+		query.Start(c)
+	}
+	iter := query.Run(ctx)
+
+	for processed < limit {
+		w := new(Widget)
+		key, err := iter.Next(w)
+		if err == datastore.Done {
+			return "", processed, nil
+		} else if err != nil {
+			return "", processed, err
+		}
+		w.ctx = ctx
+		w.key = key
+		if err := fn(w); err != nil {
+			return "", processed, fmt.Errorf("widget %s: %s", w.ID, err)
+		}
+		processed++
+	}
+
+	c, err := iter.Cursor()
+	if err != nil {
+		return "", processed, err
+	}
+	return c.String(), processed, nil
+}
+
+func cronRescore(ctx appengine.Context, cursor string) (string, int, os.Error) {
+	return batchWidgets(ctx, cursor, 20, func(w *Widget) os.Error {
+		score := w.CachedScore
+		w.dirty = true
+		if err := w.populate(); err != nil {
+			return err
+		}
+		fireScoreChanged(ctx, w, score)
+		return w.Commit()
+	})
+}
+
+// countableRetention is how long builds and commits are kept before they are
+// rolled up.
+const countableRetention = datastore.Time(90 * 24 * 60 * 60 * 1e6)
+
+func cronExpire(ctx appengine.Context, cursor string) (string, int, os.Error) {
+	return batchWidgets(ctx, cursor, 5, func(w *Widget) os.Error {
+		if err := w.populate(); err != nil {
+			return err
+		}
+
+		// Keep everything since the last commit, so builds at HEAD still count
+		cutoff := now() - countableRetention
+		if last := w.stats.CommitLast; last > 0 && last < cutoff {
+			cutoff = last
+		}
+
+		var expired int
+		for _, kind := range []string{"Build", "Commit"} {
+			n, err := ExpireCountables(ctx, w.key, kind, cutoff)
+			if err != nil {
+				return err
+			}
+			expired += n
+		}
+
+		deliveries, err := ExpireDeliveries(ctx, w.key, now() - deliveryRetention)
+		if err != nil {
+			return err
+		}
+		if deliveries > 0 {
+			ctx.Infof("Expire: Widget %s: %d webhook deliveries deleted", w.ID, deliveries)
+		}
+
+		if expired > 0 {
+			ctx.Infof("Expire: Widget %s: %d rolled up", w.ID, expired)
+			return InvalidateStats(ctx, w.ID)
+		}
+		return nil
+	})
+}
+
+type cronJobHistory struct {
+	Name        string
+	Description string
+	Run         []*CronRun
+}
+
+type cronData struct {
+	CSS    string
+	Header string
+	Job    []*cronJobHistory
+}
+
+func adminCron(w http.ResponseWriter, r *http.Request) {
+	var err os.Error
+	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
+
+	data := cronData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+	}
+
+	for _, job := range cronJobs {
+		history := &cronJobHistory{
+			Name:        job.Name,
+			Description: job.Description,
+		}
+		if history.Run, err = LoadCronRuns(ctx, job.Name, 10); err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+		data.Job = append(data.Job, history)
+	}
+
+	if err := renderPage(w, loc, "cron.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
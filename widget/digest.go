@@ -0,0 +1,138 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"template"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+var digestTemplate = `` +
+	`Here is how your projects on Go-Widget are doing:
+{.repeated section Widget}
+
+{Name} - {Score}/5
+  Rating:  {Rating}
+  Broken:  {Broken}
+  Builds:  {CompileWeek} this week, {CompileTotal} total, last {CompileDate}
+  Commits: {CheckinWeek} this week, {CheckinTotal} total, last {CheckinDate}
+{.end}
+
+--
+Go-Widget
+http://go-widget.appspot.com/widget/list
+`
+
+type digestData struct {
+	Owner  string
+	Widget []*Widget
+}
+
+// digestDay is the day of the week on which weekly digests are sent.
+const digestDay = 1 // Monday
+
+// sendDigest mails an owner a summary of their widgets, if they want one
+// today.
+func sendDigest(ctx appengine.Context, owner string, widgets []*Widget) os.Error {
+	profile, err := LoadProfile(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	freq := profile.DigestFrequency()
+	switch {
+	case freq == digestNever:
+		return nil
+	case freq == digestWeekly && time.UTC().Weekday != digestDay:
+		return nil
+	}
+
+	page, err := template.Parse(digestTemplate, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range widgets {
+		w.zone = profile.TimeZone
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := page.Execute(buf, &digestData{owner, widgets}); err != nil {
+		return err
+	}
+
+	return newMailer(ctx).Send(&Mail{
+		To:      []string{owner},
+		Subject: fmt.Sprintf("Your %s Go-Widget digest", freq),
+		Body:    buf.String(),
+	})
+}
+
+// digestBatch is the number of widgets after which a digest batch stops, once
+// it reaches the end of an owner's widgets.
+const digestBatch = 50
+
+func cronDigest(ctx appengine.Context, cursor string) (next string, processed int, err os.Error) {
+	query := datastore.NewQuery("Widget")
+	query.Order("Owner")
+	query.Order("Name")
+	if len(cursor) > 0 {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", 0, err
+		}
+		query.Start(c)
+	}
+	iter := query.Run(ctx)
+
+	var owner string
+	var widgets []*Widget
+	flush := func() os.Error {
+		if len(widgets) == 0 {
+			return nil
+		}
+		if err := sendDigest(ctx, owner, widgets); err != nil {
+			return fmt.Errorf("digest for %s: %s", owner, err)
+		}
+		processed += len(widgets)
+		widgets = nil
+		return nil
+	}
+
+	for {
+		// Remember where this widget starts, so the next batch can start
+		// with its owner if this batch is full.
+		c, err := iter.Cursor()
+		if err != nil {
+			return "", processed, err
+		}
+
+		w := new(Widget)
+		key, err := iter.Next(w)
+		if err == datastore.Done {
+			err := flush()
+			return "", processed, err
+		} else if err != nil {
+			return "", processed, err
+		}
+		w.ctx = ctx
+		w.key = key
+
+		if w.Owner != owner {
+			if processed+len(widgets) >= digestBatch {
+				err := flush()
+				return c.String(), processed, err
+			}
+			if err := flush(); err != nil {
+				return "", processed, err
+			}
+			owner = w.Owner
+		}
+		widgets = append(widgets, w)
+	}
+	panic("unreachable")
+}
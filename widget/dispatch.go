@@ -10,17 +10,47 @@ func init() {
 	http.HandleFunc("/logout", logout)
 
 	http.HandleFunc("/leaderboard", leaderBoard)
+	http.HandleFunc("/leaderboard/", leaderBoard)
 
 	http.HandleFunc("/widget/list", myWidgets)
 	http.HandleFunc("/widget/add", addWidget)
 	http.HandleFunc("/widget/show/", showWidget)
+	http.HandleFunc("/widget/element.js", elementScript)
 	http.HandleFunc("/widget/update/", updateWidget)
+	http.HandleFunc("/widget/badge/", showBadge)
+	http.HandleFunc("/widget/image/", showImage)
+	http.HandleFunc("/widget/readme/", importReadme)
+	http.HandleFunc("/widget/settings", updateProfile)
+	http.HandleFunc("/widget/webhooks/", editWebhooks)
+	http.HandleFunc("/widget/broken", resolveBroken)
+	http.HandleFunc("/widget/review", editReview)
+	http.HandleFunc("/widget/appearance/", updateAppearance)
+
+	http.HandleFunc("/p/", showProject)
+	http.HandleFunc("/search", searchPage)
+	http.HandleFunc("/oembed", oembed)
+
+	http.HandleFunc("/feed/", siteFeed)
+	http.HandleFunc("/feed/p/", widgetFeed)
+	http.HandleFunc("/feed/user/", userFeed)
 
 	http.HandleFunc("/hook/", hookCountable)
 
 	http.HandleFunc("/task/", fourOhFour)
 	http.HandleFunc("/task/upgrade", taskUpgrade)
+	http.HandleFunc("/task/migrate", taskMigrate)
 	http.HandleFunc("/task/refresh/", taskRefresh)
+	http.HandleFunc("/task/metrics", taskMetrics)
+	http.HandleFunc("/task/cron/", taskCron)
+	http.HandleFunc("/task/tags/merge", taskMergeTag)
+	http.HandleFunc("/task/notify", taskNotify)
+	http.HandleFunc("/task/webhook", taskWebhook)
+
+	http.HandleFunc("/admin/", adminIndex)
+	http.HandleFunc("/admin/migrations", adminMigrations)
+	http.HandleFunc("/admin/cron", adminCron)
+	http.HandleFunc("/admin/tags", adminTags)
+	http.HandleFunc("/admin/reviews", adminReviews)
 
 	// TODO(kevlar): Remove things that don't build with release
 	// http://go.googlecode.com/hg/.hgtags | grep release\. | sort -n | tail -n 1
@@ -0,0 +1,138 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"json"
+)
+
+// Besides the document.write script, widgets can be embedded in an iframe,
+// which shows /widget/show/{ID}/widget.html, or with the <go-widget> custom
+// element from /widget/element.js, which renders /widget/show/{ID}/widget.json
+// into its shadow DOM.  Neither needs document.write, and neither runs inline
+// script on the embedding page.
+
+// writeWidgetFrame writes the widget as a page of its own, for an iframe.
+// Links open in a new window rather than inside the frame.
+func writeWidgetFrame(w http.ResponseWriter, widget *Widget) {
+	buf := bytes.NewBuffer(nil)
+	if err := widget.Execute(buf); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n")
+	fmt.Fprintf(w, "<meta charset=\"utf-8\"/>\n<title>%s</title>\n", escapeHTML(widget.Name))
+	fmt.Fprintf(w, "<base target=\"_blank\"/>\n<style type=\"text/css\">body { margin: 0; }</style>\n")
+	fmt.Fprintf(w, "</head>\n<body>\n%s</body>\n</html>\n", buf)
+}
+
+// writeWidgetJSON writes the widget's stats, along with the widget rendered
+// without its script, for the custom element.  It can be fetched from any
+// origin.
+func writeWidgetJSON(w http.ResponseWriter, widget *Widget) {
+	html := bytes.NewBuffer(nil)
+	writeWidgetCSS(html, widget.currentTheme())
+	if err := widget.executeBody(html); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"id":          widget.ID,
+		"name":        widget.Name,
+		"url":         siteURL + "/p/" + widget.ID,
+		"home":        widget.HomeURL,
+		"source":      widget.SourceURL,
+		"bugs":        widget.BugURL,
+		"summary":     widget.Summary,
+		"unavailable": widget.Unavailable(),
+		"stale":       widget.Stale(),
+		"html":        html.String(),
+	}
+	if !widget.Unavailable() {
+		data["score"] = widget.Score()
+		data["rating"] = widget.Rating()
+		data["rated"] = widget.Rated()
+		data["broken"] = widget.Broken()
+		data["builds"] = map[string]interface{}{
+			"week":  widget.CompileWeek(),
+			"total": widget.CompileTotal(),
+			"last":  widget.CompileDate(),
+		}
+		data["commits"] = map[string]interface{}{
+			"week":  widget.CheckinWeek(),
+			"total": widget.CheckinTotal(),
+			"last":  widget.CheckinDate(),
+		}
+	}
+
+	out, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(out)
+}
+
+// elementJS defines the <go-widget> custom element.  The id attribute names
+// the widget, and the theme, layout, sections and lang attributes override its
+// appearance and language like the query parameters of the other embeds.  Links in the
+// widget are relative to this app, so they are made absolute.
+var elementJS = `` +
+	`(function() {
+	var script = document.currentScript;
+	var base = script ? script.src.replace(/\/widget\/element\.js.*$/, "") : "` + siteURL + `";
+
+	` + widgetTabsJS + `
+
+	if (!window.customElements || customElements.get("go-widget")) return;
+
+	class GoWidget extends HTMLElement {
+		connectedCallback() {
+			if (this.shadowRoot) return;
+			var root = this.attachShadow({mode: "open"});
+
+			var query = [];
+			["theme", "layout", "sections", "lang"].forEach(function(name) {
+				if (this.hasAttribute(name)) {
+					query.push(name + "=" + encodeURIComponent(this.getAttribute(name)));
+				}
+			}, this);
+			var url = base + "/widget/show/" + encodeURIComponent(this.id) + "/widget.json";
+			if (query.length > 0) url += "?" + query.join("&");
+
+			fetch(url).then(function(response) {
+				if (!response.ok) throw new Error(response.status + " " + response.statusText);
+				return response.json();
+			}).then(function(data) {
+				root.innerHTML = data.html;
+				var links = root.querySelectorAll("a[href^=\"/\"]");
+				for (var i = 0; i < links.length; i++) {
+					links[i].href = base + links[i].getAttribute("href");
+				}
+				gowidgetListen(root);
+				gowidgetTimes(root);
+			}).catch(function(err) {
+				root.textContent = "Go-Widget: " + err.message;
+			});
+		}
+	}
+	customElements.define("go-widget", GoWidget);
+})();
+`
+
+var elementETag = Hashf("%s", elementJS)
+
+func elementScript(w http.ResponseWriter, r *http.Request) {
+	setCacheHeaders(w, cachePublic, true)
+	if notModified(w, r, elementETag) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript")
+	fmt.Fprint(w, elementJS)
+}
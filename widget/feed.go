@@ -0,0 +1,412 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"sort"
+	"strings"
+	"template"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+const (
+	siteURL    = "http://go-widget.appspot.com"
+	feedLength = 20
+)
+
+// A feed is rendered as either Atom or RSS.
+type feed struct {
+	Title   string
+	ID      string
+	Link    string
+	Self    string
+	Updated datastore.Time
+	Entry   []*feedEntry
+}
+
+type feedEntry struct {
+	Title   string
+	ID      string
+	Link    string
+	Author  string
+	Summary string
+	Updated datastore.Time
+}
+
+func (f *feed) UpdatedAtom() string      { return atomTime(f.Updated) }
+func (f *feed) UpdatedRSS() string       { return rssTime(f.Updated) }
+func (e *feedEntry) UpdatedAtom() string { return atomTime(e.Updated) }
+func (e *feedEntry) UpdatedRSS() string  { return rssTime(e.Updated) }
+
+func atomTime(t datastore.Time) string {
+	return time.SecondsToUTC(int64(t) / 1e6).Format(time.RFC3339)
+}
+
+func rssTime(t datastore.Time) string {
+	return time.SecondsToUTC(int64(t) / 1e6).Format(time.RFC1123)
+}
+
+// feedID returns a tag URI for something in a feed.
+func feedID(format string, args ...interface{}) string {
+	return "tag:go-widget.appspot.com,2011:" + fmt.Sprintf(format, args...)
+}
+
+type feedEntries []*feedEntry
+
+func (e feedEntries) Len() int           { return len(e) }
+func (e feedEntries) Less(i, j int) bool { return e[i].Updated > e[j].Updated }
+func (e feedEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// finish sorts the entries newest first, drops any beyond limit, and dates
+// the feed by its newest entry.
+func (f *feed) finish(limit int) {
+	sort.Sort(feedEntries(f.Entry))
+	if len(f.Entry) > limit {
+		f.Entry = f.Entry[:limit]
+	}
+	f.Updated = now()
+	if len(f.Entry) > 0 {
+		f.Updated = f.Entry[0].Updated
+	}
+}
+
+var atomTemplate = template.MustParse(`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>{Title|html}</title>
+	<id>{ID|html}</id>
+	<link href="{Link|html}"/>
+	<link rel="self" href="{Self|html}"/>
+	<updated>{UpdatedAtom}</updated>
+	<author><name>Go-Widget</name></author>
+{.repeated section Entry}
+	<entry>
+		<title>{Title|html}</title>
+		<id>{ID|html}</id>
+		<link href="{Link|html}"/>
+		<updated>{UpdatedAtom}</updated>
+{.section Author}
+		<author><name>{@|html}</name></author>
+{.end}
+		<summary>{Summary|html}</summary>
+	</entry>
+{.end}
+</feed>
+`, nil)
+
+var rssTemplate = template.MustParse(`<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0">
+<channel>
+	<title>{Title|html}</title>
+	<link>{Link|html}</link>
+	<description>{Title|html}</description>
+	<lastBuildDate>{UpdatedRSS}</lastBuildDate>
+{.repeated section Entry}
+	<item>
+		<title>{Title|html}</title>
+		<guid isPermaLink="false">{ID|html}</guid>
+		<link>{Link|html}</link>
+		<pubDate>{UpdatedRSS}</pubDate>
+		<description>{Summary|html}</description>
+	</item>
+{.end}
+</channel>
+</rss>
+`, nil)
+
+// writeFeed writes the feed in the format named by the extension of the
+// request path.
+func writeFeed(w http.ResponseWriter, r *http.Request, f *feed) {
+	ctx := appengine.NewContext(r)
+	page, contentType := atomTemplate, "application/atom+xml"
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		page, contentType = rssTemplate, "application/rss+xml"
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	if err := page.Execute(w, f); err != nil {
+		ctx.Errorf("Feed %s: %s", r.URL.Path, err)
+	}
+}
+
+// feedName returns the name of the feed from the last element of the path,
+// and whether it has a known extension.
+func feedName(path string) (name string, ok bool) {
+	name = path[strings.LastIndex(path, "/")+1:]
+	for _, ext := range []string{".atom", ".rss"} {
+		if strings.HasSuffix(name, ext) {
+			return name[:len(name)-len(ext)], true
+		}
+	}
+	return name, false
+}
+
+// countableEntries returns the feed entries for the recent activity of a
+// widget, with times in the given time zone from a profile.
+func countableEntries(ctx appengine.Context, widget *Widget, zone string) (entries []*feedEntry, err os.Error) {
+	kinds := []struct {
+		Kind, Title string
+	}{
+		{"Commit", "Commit"},
+		{"Build", "Successful build"},
+		{"BuildFail", "Failed build"},
+	}
+	for _, kind := range kinds {
+		cs, err := LoadRecentCountable(ctx, kind.Kind, widget.ID, feedLength)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cs {
+			entry := &feedEntry{
+				Title:   fmt.Sprintf("%s: %s", widget.Name, kind.Title),
+				ID:      feedID("%s/%s", kind.Kind, c.key.StringID()),
+				Link:    siteURL + "/p/" + widget.ID,
+				Updated: c.Time,
+				Summary: fmt.Sprintf("%s for %s at %s", kind.Title, widget.Name, timeIn(c.Time, zone)),
+			}
+			if len(c.Rev) > 0 {
+				entry.Title += " " + truncate(c.Rev, 12)
+			}
+			if len(c.Author) > 0 {
+				entry.Author = c.Author
+			}
+			if len(c.Message) > 0 {
+				entry.Summary = c.Message
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	reports, err := LoadBrokenReports(ctx, widget.ID, feedLength)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range reports {
+		entry := &feedEntry{
+			Title:   fmt.Sprintf("%s: Won't build report (%s)", widget.Name, b.Status),
+			ID:      feedID("Broken/%s", b.key.StringID()),
+			Link:    siteURL + "/p/" + widget.ID,
+			Updated: b.Time,
+			Summary: fmt.Sprintf("Won't build report for %s at %s", widget.Name, timeIn(b.Time, zone)),
+		}
+		if len(b.Reason) > 0 {
+			entry.Summary = b.Reason
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// widgetFeed serves /feed/p/{widget}.atom, the activity of one widget.
+func widgetFeed(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	widgethash, ok := feedName(r.URL.Path)
+	widgethash = strings.ToUpper(widgethash)
+	if !ok || len(widgethash) != 32 {
+		http.Error(w, "/feed/p/{widget}.atom - invalid feed", http.StatusNotFound)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusNotFound)
+		return
+	}
+
+	f := &feed{
+		Title: widget.Name + " on Go-Widget",
+		ID:    feedID("p/%s", widget.ID),
+		Link:  siteURL + "/p/" + widget.ID,
+		Self:  siteURL + r.URL.Path,
+	}
+	if f.Entry, err = countableEntries(ctx, widget, "UTC"); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	f.finish(feedLength)
+
+	writeFeed(w, r, f)
+}
+
+// userFeed serves /feed/user/{token}.atom, the activity of a user's widgets.
+func userFeed(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	token, ok := feedName(r.URL.Path)
+	if !ok || len(token) != 32 {
+		http.Error(w, "/feed/user/{token}.atom - invalid feed", http.StatusNotFound)
+		return
+	}
+
+	profile, err := LoadProfileByFeed(ctx, token)
+	if err != nil {
+		http.Error(w, "Unknown feed", http.StatusNotFound)
+		return
+	}
+
+	widgets, err := LoadWidgetsByOwner(ctx, profile.Email)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	f := &feed{
+		Title: "My projects on Go-Widget",
+		ID:    feedID("user/%s", token),
+		Link:  siteURL + "/widget/list",
+		Self:  siteURL + r.URL.Path,
+	}
+	for _, widget := range widgets {
+		entries, err := countableEntries(ctx, widget, profile.TimeZone)
+		if err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+		f.Entry = append(f.Entry, entries...)
+	}
+	f.finish(2 * feedLength)
+
+	writeFeed(w, r, f)
+}
+
+// siteFeed serves the site-wide feeds: /feed/new.atom, the newest widgets,
+// and /feed/movers.atom, the widgets which moved between the last two leader
+// board snapshots.
+func siteFeed(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	name, ok := feedName(r.URL.Path)
+	if !ok {
+		fourOhFour(w, r)
+		return
+	}
+
+	var f *feed
+	var err os.Error
+	switch name {
+	case "new":
+		f, err = newWidgetsFeed(ctx)
+	case "movers":
+		f, err = moversFeed(ctx)
+	default:
+		fourOhFour(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	f.Self = siteURL + r.URL.Path
+
+	writeFeed(w, r, f)
+}
+
+func newWidgetsFeed(ctx appengine.Context) (*feed, os.Error) {
+	widgets, _, err := LoadLeaderBoard(ctx, &LeaderBoardQuery{Sort: "newest"})
+	if err != nil {
+		return nil, err
+	}
+
+	f := &feed{
+		Title: "New projects on Go-Widget",
+		ID:    feedID("new"),
+		Link:  siteURL + "/leaderboard?sort=newest",
+	}
+	for _, widget := range widgets {
+		summary := widget.Summary
+		if len(summary) == 0 {
+			summary = widget.Name + " was added to Go-Widget"
+		}
+		f.Entry = append(f.Entry, &feedEntry{
+			Title:   widget.Name,
+			ID:      feedID("p/%s", widget.ID),
+			Link:    siteURL + "/p/" + widget.ID,
+			Summary: summary,
+			Updated: widget.Created,
+		})
+	}
+	f.finish(feedLength)
+	return f, nil
+}
+
+func moversFeed(ctx appengine.Context) (*feed, os.Error) {
+	snaps, err := LoadSnapshots(ctx, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &feed{
+		Title: "Leader board movers on Go-Widget",
+		ID:    feedID("movers"),
+		Link:  siteURL + "/leaderboard",
+	}
+	if len(snaps) < 2 {
+		f.finish(feedLength)
+		return f, nil
+	}
+	cur, prev := snaps[0], snaps[1]
+
+	before := make(map[string]int)
+	for i, id := range prev.ID {
+		before[id] = i + 1
+	}
+
+	var keys []*datastore.Key
+	var moves []string
+	for i, id := range cur.ID {
+		rank := i + 1
+		var move string
+		switch old, ok := before[id]; {
+		case !ok:
+			move = fmt.Sprintf("entered the leader board at #%d", rank)
+		case old > rank:
+			move = fmt.Sprintf("moved up from #%d to #%d", old, rank)
+		case old < rank:
+			move = fmt.Sprintf("moved down from #%d to #%d", old, rank)
+		default:
+			continue
+		}
+		keys = append(keys, datastore.NewKey("Widget", id, 0, nil))
+		moves = append(moves, move)
+	}
+
+	widgets := make([]*Widget, len(keys))
+	for i := range widgets {
+		widgets[i] = new(Widget)
+	}
+
+	// Widgets deleted since the snapshot are left out
+	err = datastore.GetMulti(ctx, keys, widgets)
+	deleted := make([]bool, len(keys))
+	if multi, ok := err.(datastore.ErrMulti); ok {
+		for i, err := range multi {
+			switch err {
+			case nil:
+			case datastore.ErrNoSuchEntity:
+				deleted[i] = true
+			default:
+				return nil, err
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, widget := range widgets {
+		if deleted[i] {
+			continue
+		}
+		f.Entry = append(f.Entry, &feedEntry{
+			Title:   widget.Name + " " + moves[i],
+			ID:      feedID("movers/%d/%s", cur.Time, widget.ID),
+			Link:    siteURL + "/p/" + widget.ID,
+			Summary: fmt.Sprintf("%s %s with a score of %d/5", widget.Name, moves[i], widget.CachedScore),
+			Updated: cur.Time,
+		})
+	}
+	f.finish(feedLength)
+	return f, nil
+}
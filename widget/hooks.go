@@ -19,9 +19,13 @@ func hookCountable(w http.ResponseWriter, r *http.Request) {
 
 	var uniqueKey int64
 	var countable string
+	var retract bool
 	switch path[1] {
 	case "plusone":
 		countable = "Rating"
+	case "unrate":
+		countable = "Rating"
+		retract = true
 	case "wontbuild":
 		countable = "Broken"
 	case "compile":
@@ -30,6 +34,9 @@ func hookCountable(w http.ResponseWriter, r *http.Request) {
 	case "commit":
 		countable = "Commit"
 		uniqueKey = int64(now())
+	case "buildfail":
+		countable = "BuildFail"
+		uniqueKey = int64(now())
 	default:
 		http.Error(w, "/hook/{type}/{widget} - unknown type", http.StatusBadRequest)
 		return
@@ -40,7 +47,8 @@ func hookCountable(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid widget id: " + widget, http.StatusBadRequest)
 		return
 	}
-	if _, err := LoadWidget(ctx, widget); err != nil {
+	target, err := LoadWidget(ctx, widget)
+	if err != nil {
 		http.Error(w, "Unknown widget id: " + widget, http.StatusBadRequest)
 		return
 	}
@@ -51,16 +59,103 @@ func hookCountable(w http.ResponseWriter, r *http.Request) {
 	}
 
 	keyhash := Hashf("IP=%s|Unique=%d", ip, uniqueKey)
+	if countable == "Rating" {
+		// One rating per person, which they can take back
+		keyhash = voterHash(ctx, r)
+	}
 	obj := NewCountable(ctx, countable, widget, keyhash)
-	if err := obj.Commit(); err != nil {
+	if countable == "Commit" || countable == "BuildFail" {
+		obj.Rev = truncate(r.FormValue("rev"), 64)
+		obj.Author = truncate(r.FormValue("author"), 100)
+		obj.Message = truncate(r.FormValue("message"), 500)
+	}
+
+	// Broken reports carry their own details, and replace any earlier
+	// report from the same address
+	var report *BrokenReport
+	if countable == "Broken" {
+		report = NewBrokenReport(ctx, widget, keyhash)
+		report.Reason = truncate(r.FormValue("reason"), 500)
+		report.GoVersion = truncate(r.FormValue("goversion"), 64)
+		report.Platform = truncate(r.FormValue("platform"), 64)
+	}
+
+	// Whether this is the first build since the last commit has to be
+	// checked before the build is recorded
+	var atHead bool
+	if countable == "Build" {
+		first, err := firstBuildAtHead(ctx, target)
+		if err != nil {
+			ctx.Warningf("Hook: build at head %s: %s", widget, err)
+		}
+		atHead = first
+	}
+
+	switch {
+	case retract:
+		err = obj.Delete()
+	case report != nil:
+		err = report.Commit()
+	default:
+		err = obj.Commit()
+	}
+	if err != nil {
 		http.Error(w, err.String(), http.StatusInternalServerError)
 		return
 	}
 
+	details := map[string]interface{}{
+		"rev": obj.Rev,
+		"message": obj.Message,
+	}
+	switch countable {
+	case "Broken":
+		// Reports from one address share a key, so the time tells them apart
+		name := fmt.Sprintf("%s%s-%d", widget, keyhash, report.Time)
+		if err := QueueNotify(ctx, "broken", name, &notifyData{
+			Widget: target,
+			Message: report.Reason,
+			GoVersion: report.GoVersion,
+			Platform: report.Platform,
+		}); err != nil {
+			ctx.Errorf("Hook: notify %s: %s", widget, err)
+		}
+		err = FireEvent(ctx, target, "broken", map[string]interface{}{
+			"reason": report.Reason,
+			"goversion": report.GoVersion,
+			"platform": report.Platform,
+		})
+	case "BuildFail":
+		if err := QueueNotify(ctx, "buildfail", widget + keyhash, &notifyData{
+			Widget: target,
+			Rev: obj.Rev,
+			Message: obj.Message,
+		}); err != nil {
+			ctx.Errorf("Hook: notify %s: %s", widget, err)
+		}
+		err = FireEvent(ctx, target, "buildfail", details)
+	case "Build":
+		if atHead {
+			err = FireEvent(ctx, target, "buildhead", nil)
+		}
+	}
+	if err != nil {
+		ctx.Errorf("Hook: webhooks %s: %s", widget, err)
+	}
+
+	// Don't serve the old stats while the refresh is queued
+	if err := InvalidateStats(ctx, widget); err != nil {
+		ctx.Warningf("Hook: invalidate %s: %s", widget, err)
+	}
+
 	refreshWidget(w,r,widget)
 
 	// TODO(kevlar): Referer?
 	//http.Redirect(w, r, "/widget/list", http.StatusFound)
+	if r.FormValue("redirect") == "project" {
+		http.Redirect(w, r, "/p/" + widget, http.StatusFound)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	fmt.Fprintf(w, "OK")
@@ -0,0 +1,650 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"io"
+	"strconv"
+	"strings"
+	"template"
+
+	"appengine/datastore"
+)
+
+// Pages and widgets are shown in the language the viewer asks for with a
+// lang parameter (e.g. widget.js?lang=de), or else in the one their browser
+// prefers most in its Accept-Language header.  Templates show messages with
+// {T.Key}, which look up Key in the Locale's catalog, and numbers with the
+// number formatter.
+
+// A Locale is a language the site is translated into.
+type Locale struct {
+	Name      string // e.g. "de"
+	Title     string // in the language itself, e.g. "Deutsch"
+	Thousands string // separates groups of digits, e.g. "1,000"
+
+	// Messages are looked up by key.  Relative times are in the messages
+	// Ago{Unit}One and Ago{Unit}Other, with a %d for the number.
+	Messages map[string]string
+}
+
+// The first locale is the default, and the one messages missing from the
+// others are taken from.
+var locales = []*Locale{
+	{"en", "English", ",", enMessages},
+	{"de", "Deutsch", ".", deMessages},
+}
+
+func init() {
+	for _, l := range locales[1:] {
+		for key, text := range locales[0].Messages {
+			if _, ok := l.Messages[key]; !ok {
+				l.Messages[key] = text
+			}
+		}
+	}
+}
+
+func findLocale(name string) *Locale {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if dash := strings.Index(name, "-"); dash >= 0 {
+		name = name[:dash]
+	}
+	for _, l := range locales {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// localeFor returns the locale to show a request in.
+func localeFor(r *http.Request) *Locale {
+	if l := findLocale(r.FormValue("lang")); l != nil {
+		return l
+	}
+
+	var best *Locale
+	var bestQ float64
+	for _, lang := range strings.Split(r.Header.Get("Accept-Language"), ",", -1) {
+		q := 1.0
+		if semi := strings.Index(lang, ";"); semi >= 0 {
+			if param := strings.TrimSpace(lang[semi+1:]); strings.HasPrefix(param, "q=") {
+				q, _ = strconv.Atof64(param[len("q="):])
+			}
+			lang = lang[:semi]
+		}
+		if l := findLocale(lang); l != nil && q > bestQ {
+			best, bestQ = l, q
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return locales[0]
+}
+
+// Text returns the message with the given key.
+func (l *Locale) Text(key string) string {
+	if text, ok := l.Messages[key]; ok {
+		return text
+	}
+	return key
+}
+
+// Number formats n with its digits grouped in thousands.
+func (l *Locale) Number(n int64) string {
+	digits := strconv.Itoa64(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + l.Thousands + digits[i:]
+	}
+	return sign + digits
+}
+
+// relativeUnits are the units relative times are given in, largest first.
+var relativeUnits = []struct {
+	Name    string
+	Seconds int64
+}{
+	{"Year", 365 * 24 * 60 * 60},
+	{"Month", 30 * 24 * 60 * 60},
+	{"Week", 7 * 24 * 60 * 60},
+	{"Day", 24 * 60 * 60},
+	{"Hour", 60 * 60},
+	{"Minute", 60},
+}
+
+// Ago describes a time the given number of seconds ago, e.g. "3 hours ago",
+// in the largest unit it is at least one of.
+func (l *Locale) Ago(seconds int64) string {
+	for _, unit := range relativeUnits {
+		n := seconds / unit.Seconds
+		switch {
+		case n == 1:
+			return fmt.Sprintf(l.Text("Ago"+unit.Name+"One"), n)
+		case n > 1:
+			return fmt.Sprintf(l.Text("Ago"+unit.Name+"Other"), n)
+		}
+	}
+	return l.Text("JustNow")
+}
+
+// formatters returns the template formatters for the locale.  number formats
+// integers with Number, and iso formats a datastore.Time with isotime, for
+// the datetime of a <time> element.
+func (l *Locale) formatters() template.FormatterMap {
+	return template.FormatterMap{
+		"number": func(w io.Writer, format string, values ...interface{}) {
+			for _, v := range values {
+				switch n := v.(type) {
+				case int:
+					io.WriteString(w, l.Number(int64(n)))
+				case int64:
+					io.WriteString(w, l.Number(n))
+				default:
+					fmt.Fprint(w, v)
+				}
+			}
+		},
+		"iso": func(w io.Writer, format string, values ...interface{}) {
+			for _, v := range values {
+				if t, ok := v.(datastore.Time); ok {
+					io.WriteString(w, isotime(t))
+				} else {
+					fmt.Fprint(w, v)
+				}
+			}
+		},
+	}
+}
+
+// loc returns the locale the widget is shown in.
+func (w *Widget) loc() *Locale {
+	if w.locale == nil {
+		return locales[0]
+	}
+	return w.locale
+}
+
+// T returns the messages of the locale the widget is shown in, for {T.Key}.
+func (w *Widget) T() map[string]string {
+	return w.loc().Messages
+}
+
+// Messages shown in the widget are written out by document.write, so they
+// must not contain single quotes.
+var enMessages = map[string]string{
+	// Relative times
+	"JustNow":        "just now",
+	"Never":          "never",
+	"AgoMinuteOne":   "%d minute ago",
+	"AgoMinuteOther": "%d minutes ago",
+	"AgoHourOne":     "%d hour ago",
+	"AgoHourOther":   "%d hours ago",
+	"AgoDayOne":      "%d day ago",
+	"AgoDayOther":    "%d days ago",
+	"AgoWeekOne":     "%d week ago",
+	"AgoWeekOther":   "%d weeks ago",
+	"AgoMonthOne":    "%d month ago",
+	"AgoMonthOther":  "%d months ago",
+	"AgoYearOne":     "%d year ago",
+	"AgoYearOther":   "%d years ago",
+
+	// Header
+	"Search":          "Search",
+	"LogIn":           "Log In",
+	"LogOut":          "Log Out",
+	"MyProjects":      "My Projects",
+	"Admin":           "Admin",
+	"PopularProjects": "Popular Projects",
+
+	// Widget
+	"StatsOutOfDate":   "Stats may be out of date",
+	"StatsUnavailable": "Stats temporarily unavailable",
+	"Stale":            "stale",
+	"Details":          "Details",
+	"Project":          "Project",
+	"Builds":           "Builds",
+	"Commits":          "Commits",
+	"PoweredBy":        "Powered by",
+	"Rating":           "Rating",
+	"GiveRating":       "Give a +1",
+	"TakeBackRating":   "Take back your +1",
+	"Broken":           "Broken",
+	"SourceCode":       "Source Code",
+	"Source":           "Source",
+	"ReportBug":        "Report Bug",
+	"Bugs":             "Bugs",
+	"Activity":         "Builds and commits per week",
+	"Build":            "Build",
+	"Commit":           "Commit",
+	"Weekly":           "Weekly",
+	"Total":            "Total",
+	"Last":             "Last",
+	"LastBuild":        "Last build",
+	"LastCommit":       "Last commit",
+	"BuildsThisWeek":   "builds this week",
+	"CommitsThisWeek":  "commits this week",
+	"ThisWeek":         "this week",
+	"InTotal":          "total",
+	"LastTime":         "last",
+
+	// Image, which is plain text rather than HTML
+	"ImageUnavailable": "Stats unavailable",
+	"ImageScore":       "Score: %d/5",
+	"ImageRating":      "Rating: %s",
+	"ImageLastBuild":   "Last build: %s",
+
+	// Leader board
+	"LeaderBoard":        "Project Leader Board",
+	"NewProjects":        "New projects",
+	"LeaderBoardMovers":  "Leader board movers",
+	"LeaderBoardOffline": "Stats temporarily unavailable, please try again later.",
+	"LeaderBoardStale":   "Stats temporarily unavailable, showing an earlier leader board.",
+	"Projects":           "projects",
+	"SortBy":             "Sort by:",
+	"SortScore":          "Score",
+	"SortRating":         "Rating",
+	"SortBuilds":         "Builds this week",
+	"SortCommits":        "Recent commits",
+	"SortNewest":         "Newest",
+	"MinimumScore":       "Minimum score:",
+	"AnyScore":           "Any",
+	"Tag":                "Tag:",
+	"Filter":             "Filter",
+	"Score":              "Score",
+	"Home":               "Home",
+	"ReportABug":         "Report a Bug",
+	"NoProjects":         "No projects found",
+	"FirstPage":          "First page",
+	"NextPage":           "Next page",
+
+	// My Widgets
+	"MyWidgets":         "My Widgets",
+	"FeedIntro":         "Follow the activity of your projects with",
+	"PrivateFeed":       "your private feed",
+	"Settings":          "Settings",
+	"NotifyBroken":      "Email me when one of my projects is reported broken",
+	"NotifyBuildFail":   "Email me when a build of one of my projects fails",
+	"SendDigest":        "Send me a digest",
+	"DigestDaily":       "daily",
+	"DigestWeekly":      "weekly",
+	"DigestNever":       "never",
+	"TimeZone":          "Show times in",
+	"BrowserTimeZone":   "my browser's time zone",
+	"SaveSettings":      "Save Settings",
+	"Embed":             "Embed:",
+	"EmbedScript":       "Script:",
+	"EmbedIframe":       "Iframe, for pages which load scripts asynchronously or don't allow them:",
+	"EmbedElement":      "Custom element, which works with a Content-Security-Policy that allows scripts from go-widget.appspot.com:",
+	"EmbedOverrides":    "The <code>theme</code>, <code>layout</code>, <code>sections</code> and <code>lang</code> settings can be given as query parameters of the script and iframe URLs, or as attributes of the custom element.",
+	"RatingCriteria":    "Rating:",
+	"Current":           "Current",
+	"ProjectPage":       "Project page",
+	"HomeURL":           "Home:",
+	"SourceURL":         "Source:",
+	"BugURL":            "Create Bug:",
+	"Summary":           "Summary:",
+	"EmbedSummary":      "Show the summary in the embedded widget",
+	"Description":       "Description:",
+	"MarkdownHelp":      "Markdown: # headings, *emphasis*, **strong**, &#96;code&#96;, [links](http://...), - lists",
+	"Category":          "Category:",
+	"NoCategory":        "(none)",
+	"Tags":              "Tags:",
+	"TagsExample":       "(e.g. web, database, cli)",
+	"Update":            "Update",
+	"ImportReadme":      "Import README from Source",
+	"ImportReadmeHelp":  "(replaces the description)",
+	"Appearance":        "Appearance",
+	"Theme":             "Theme:",
+	"ThemeHelp":         "(<code>auto</code> follows the viewer's dark mode setting; embeds can override this with <code>?theme=</code>)",
+	"CustomTheme":       "Custom:",
+	"CustomThemeHelp":   "Text, secondary text, border, light and background colors for each palette. Both text colors need a contrast of at least 4.5:1 with the background.",
+	"Layout":            "Layout:",
+	"LayoutHelp":        "(<code>compact</code> fits on one line; embeds can override this with <code>?layout=</code>)",
+	"Sections":          "Sections:",
+	"SectionsHelp":      "In the order to show them, from:",
+	"SectionsOverride":  "Embeds can override this with <code>?sections=</code>",
+	"SaveAppearance":    "Save Appearance",
+	"Hooks":             "Hooks",
+	"CommitHook":        "Commit Hook URL:",
+	"CommitHookHelp":    "Optionally, add <code>rev</code>, <code>author</code> and <code>message</code> parameters to describe the commit.",
+	"BuildFailHook":     "Build Failure Hook URL:",
+	"BuildFailHookHelp": "Optionally, add <code>rev</code> and <code>message</code> parameters to describe the failure.",
+	"Webhooks":          "Webhooks",
+	"WebhooksHelp":      "Events are posted as JSON.  The X-GoWidget-Signature header is <code>sha1=</code> and the hex HMAC-SHA1 of the body, keyed with the secret.",
+	"Secret":            "Secret:",
+	"Remove":            "Remove",
+	"AddWebhook":        "Add Webhook",
+	"AddWidget":         "add",
+
+	// Score criteria, which are plain text
+	"CriterionRating":     "Rated at least +5",
+	"CriterionBuilds":     "At least 50 compiles",
+	"CriterionBuildsHead": "At least 5 compiles at HEAD",
+	"CriterionBroken":     `No more than 1 "won't build" at HEAD`,
+	"CriterionURLs":       "Set Home, Source, and Bug Report URLs",
+	"CriterionURLsOf":     "%d of 3",
+
+	// Project page
+	"PlusOne":         "+1",
+	"UnPlusOne":       "Un +1",
+	"WontBuild":       "Won&#39;t Build",
+	"ProjectOffline":  "Stats temporarily unavailable, please try again later.",
+	"ProjectStale":    "Stats temporarily unavailable, these may be out of date.",
+	"ScoreHeading":    "Score:",
+	"Stats":           "Stats",
+	"AtHead":          "At HEAD",
+	"ActivityHeading": "Activity",
+	"BuildsCount":     "builds",
+	"CommitsCount":    "commits",
+	"PerWeek":         "(per week)",
+	"RecentBuilds":    "Recent Builds",
+	"RecentCommits":   "Recent Commits",
+	"NoneYet":         "None yet",
+	"None":            "None",
+	"BrokenReports":   "Won&#39;t Build Reports",
+	"Reported":        "Reported",
+	"Go":              "Go",
+	"Platform":        "Platform",
+	"Error":           "Error",
+	"Status":          "Status",
+	"Save":            "Save",
+	"GoVersion":       "Go version:",
+	"GoVersionHelp":   "(e.g. the output of <code>go version</code>)",
+	"OSArch":          "OS/arch:",
+	"OSArchHelp":      "(e.g. linux/amd64)",
+	"ErrorLabel":      "Error:",
+	"ReportBroken":    "Report that it won&#39;t build",
+	"Reviews":         "Reviews",
+	"StarsFrom":       "out of 5 stars from",
+	"RatingsCount":    "ratings",
+	"StarsCount":      "stars",
+	"NoRatings":       "No ratings yet.",
+	"OwnerReplied":    "The owner replied:",
+	"Reply":           "Reply",
+	"FlagReview":      "Flag for moderation",
+	"NoReviews":       "No reviews yet.",
+	"YourReview":      "Your Review",
+	"NoStars":         "No rating",
+	"SaveReview":      "Save Review",
+	"DeleteReview":    "Delete Review",
+	"LogInToReview":   "<a href=\"/login\">Log in</a> to review this project.",
+	"Deliveries":      "Webhook Deliveries",
+	"Time":            "Time",
+	"Event":           "Event",
+	"URL":             "URL",
+	"Attempt":         "Attempt",
+	"Result":          "Result",
+	"EmbedHeading":    "Embed",
+	"Badge":           "Badge",
+	"BadgeAlt":        "Go-Widget score",
+	"Markdown":        "Markdown:",
+	"HTML":            "HTML:",
+	"BBCode":          "BBCode:",
+	"ImageHeading":    "Image",
+	"ImageHelp":       "For forums and mail clients which don&#39;t allow scripts or SVG.",
+	"OnGoWidget":      "on Go-Widget",
+
+	// Search, and the admin pages for tags and reviews
+	"CategoryColumn": "Category",
+	"TagsColumn":     "Tags",
+	"ProjectsColumn": "Projects",
+	"NewTag":         "New tag:",
+	"Add":            "Add",
+	"Merge":          "Merge",
+	"Into":           "into",
+	"SynonymFor":     "Synonym For",
+	"ReviewsIntro":   "Flagged reviews are listed first, then the most recent.",
+	"Author":         "Author",
+	"Stars":          "Stars",
+	"Date":           "Date",
+	"Review":         "Review",
+	"ReplyLabel":     "Reply:",
+	"Hidden":         "hidden",
+	"Flagged":        "flagged",
+	"Hide":           "Hide",
+	"Show":           "Show",
+	"Dismiss":        "Dismiss",
+	"Delete":         "Delete",
+}
+
+var deMessages = map[string]string{
+	// Relative times
+	"JustNow":        "gerade eben",
+	"Never":          "nie",
+	"AgoMinuteOne":   "vor %d Minute",
+	"AgoMinuteOther": "vor %d Minuten",
+	"AgoHourOne":     "vor %d Stunde",
+	"AgoHourOther":   "vor %d Stunden",
+	"AgoDayOne":      "vor %d Tag",
+	"AgoDayOther":    "vor %d Tagen",
+	"AgoWeekOne":     "vor %d Woche",
+	"AgoWeekOther":   "vor %d Wochen",
+	"AgoMonthOne":    "vor %d Monat",
+	"AgoMonthOther":  "vor %d Monaten",
+	"AgoYearOne":     "vor %d Jahr",
+	"AgoYearOther":   "vor %d Jahren",
+
+	// Header
+	"Search":          "Suchen",
+	"LogIn":           "Anmelden",
+	"LogOut":          "Abmelden",
+	"MyProjects":      "Meine Projekte",
+	"Admin":           "Verwaltung",
+	"PopularProjects": "Beliebte Projekte",
+
+	// Widget
+	"StatsOutOfDate":   "Statistik ist eventuell veraltet",
+	"StatsUnavailable": "Statistik vor&uuml;bergehend nicht verf&uuml;gbar",
+	"Stale":            "veraltet",
+	"Details":          "Details",
+	"Project":          "Projekt",
+	"Builds":           "Builds",
+	"Commits":          "Commits",
+	"PoweredBy":        "Bereitgestellt von",
+	"Rating":           "Bewertung",
+	"GiveRating":       "+1 vergeben",
+	"TakeBackRating":   "+1 zur&uuml;cknehmen",
+	"Broken":           "Defekt",
+	"SourceCode":       "Quellcode",
+	"Source":           "Quellcode",
+	"ReportBug":        "Fehler melden",
+	"Bugs":             "Fehler",
+	"Activity":         "Builds und Commits pro Woche",
+	"Build":            "Build",
+	"Commit":           "Commit",
+	"Weekly":           "Woche",
+	"Total":            "Gesamt",
+	"Last":             "Zuletzt",
+	"LastBuild":        "Letzter Build",
+	"LastCommit":       "Letzter Commit",
+	"BuildsThisWeek":   "Builds diese Woche",
+	"CommitsThisWeek":  "Commits diese Woche",
+	"ThisWeek":         "diese Woche",
+	"InTotal":          "gesamt",
+	"LastTime":         "zuletzt",
+
+	// Image
+	"ImageUnavailable": "Statistik nicht verfügbar",
+	"ImageScore":       "Punkte: %d/5",
+	"ImageRating":      "Bewertung: %s",
+	"ImageLastBuild":   "Letzter Build: %s",
+
+	// Leader board
+	"LeaderBoard":        "Projekt-Rangliste",
+	"NewProjects":        "Neue Projekte",
+	"LeaderBoardMovers":  "Aufsteiger der Rangliste",
+	"LeaderBoardOffline": "Statistik vor&uuml;bergehend nicht verf&uuml;gbar, bitte versuchen Sie es sp&auml;ter noch einmal.",
+	"LeaderBoardStale":   "Statistik vor&uuml;bergehend nicht verf&uuml;gbar, eine fr&uuml;here Rangliste wird angezeigt.",
+	"Projects":           "Projekte",
+	"SortBy":             "Sortieren nach:",
+	"SortScore":          "Punkte",
+	"SortRating":         "Bewertung",
+	"SortBuilds":         "Builds diese Woche",
+	"SortCommits":        "Neueste Commits",
+	"SortNewest":         "Neueste",
+	"MinimumScore":       "Mindestpunktzahl:",
+	"AnyScore":           "Alle",
+	"Tag":                "Schlagwort:",
+	"Filter":             "Filtern",
+	"Score":              "Punkte",
+	"Home":               "Startseite",
+	"ReportABug":         "Fehler melden",
+	"NoProjects":         "Keine Projekte gefunden",
+	"FirstPage":          "Erste Seite",
+	"NextPage":           "N&auml;chste Seite",
+
+	// My Widgets
+	"MyWidgets":         "Meine Widgets",
+	"FeedIntro":         "Verfolgen Sie die Aktivit&auml;t Ihrer Projekte mit",
+	"PrivateFeed":       "Ihrem privaten Feed",
+	"Settings":          "Einstellungen",
+	"NotifyBroken":      "E-Mail, wenn eines meiner Projekte als defekt gemeldet wird",
+	"NotifyBuildFail":   "E-Mail, wenn ein Build eines meiner Projekte fehlschl&auml;gt",
+	"SendDigest":        "Zusammenfassung senden",
+	"DigestDaily":       "t&auml;glich",
+	"DigestWeekly":      "w&ouml;chentlich",
+	"DigestNever":       "nie",
+	"TimeZone":          "Zeiten anzeigen in",
+	"BrowserTimeZone":   "der Zeitzone meines Browsers",
+	"SaveSettings":      "Einstellungen speichern",
+	"Embed":             "Einbetten:",
+	"EmbedScript":       "Skript:",
+	"EmbedIframe":       "Iframe, f&uuml;r Seiten, die Skripte asynchron oder gar nicht laden:",
+	"EmbedElement":      "Benutzerdefiniertes Element, funktioniert mit einer Content-Security-Policy, die Skripte von go-widget.appspot.com erlaubt:",
+	"EmbedOverrides":    "Die Einstellungen <code>theme</code>, <code>layout</code>, <code>sections</code> und <code>lang</code> k&ouml;nnen als Parameter der Skript- und Iframe-URLs oder als Attribute des benutzerdefinierten Elements angegeben werden.",
+	"RatingCriteria":    "Bewertung:",
+	"Current":           "Aktuell",
+	"ProjectPage":       "Projektseite",
+	"HomeURL":           "Startseite:",
+	"SourceURL":         "Quellcode:",
+	"BugURL":            "Fehler melden:",
+	"Summary":           "Zusammenfassung:",
+	"EmbedSummary":      "Zusammenfassung im eingebetteten Widget anzeigen",
+	"Description":       "Beschreibung:",
+	"MarkdownHelp":      "Markdown: # &Uuml;berschriften, *Betonung*, **fett**, &#96;Code&#96;, [Links](http://...), - Listen",
+	"Category":          "Kategorie:",
+	"NoCategory":        "(keine)",
+	"Tags":              "Schlagw&ouml;rter:",
+	"TagsExample":       "(z.B. web, database, cli)",
+	"Update":            "Aktualisieren",
+	"ImportReadme":      "README aus dem Quellcode importieren",
+	"ImportReadmeHelp":  "(ersetzt die Beschreibung)",
+	"Appearance":        "Aussehen",
+	"Theme":             "Farbschema:",
+	"ThemeHelp":         "(<code>auto</code> folgt der Dunkelmodus-Einstellung des Betrachters; Einbettungen k&ouml;nnen dies mit <code>?theme=</code> &uuml;berschreiben)",
+	"CustomTheme":       "Eigenes:",
+	"CustomThemeHelp":   "Text-, Nebentext-, Rahmen-, Hell- und Hintergrundfarbe jeder Palette. Beide Textfarben brauchen einen Kontrast von mindestens 4,5:1 zum Hintergrund.",
+	"Layout":            "Layout:",
+	"LayoutHelp":        "(<code>compact</code> passt in eine Zeile; Einbettungen k&ouml;nnen dies mit <code>?layout=</code> &uuml;berschreiben)",
+	"Sections":          "Abschnitte:",
+	"SectionsHelp":      "In der gew&uuml;nschten Reihenfolge, aus:",
+	"SectionsOverride":  "Einbettungen k&ouml;nnen dies mit <code>?sections=</code> &uuml;berschreiben",
+	"SaveAppearance":    "Aussehen speichern",
+	"Hooks":             "Hooks",
+	"CommitHook":        "Commit-Hook-URL:",
+	"CommitHookHelp":    "Optional beschreiben die Parameter <code>rev</code>, <code>author</code> und <code>message</code> den Commit.",
+	"BuildFailHook":     "Build-Fehler-Hook-URL:",
+	"BuildFailHookHelp": "Optional beschreiben die Parameter <code>rev</code> und <code>message</code> den Fehler.",
+	"Webhooks":          "Webhooks",
+	"WebhooksHelp":      "Ereignisse werden als JSON gesendet.  Der Header X-GoWidget-Signature ist <code>sha1=</code> gefolgt vom hexadezimalen HMAC-SHA1 des Inhalts, mit dem Geheimnis als Schl&uuml;ssel.",
+	"Secret":            "Geheimnis:",
+	"Remove":            "Entfernen",
+	"AddWebhook":        "Webhook hinzuf&uuml;gen",
+	"AddWidget":         "hinzuf&uuml;gen",
+
+	// Score criteria
+	"CriterionRating":     "Bewertung von mindestens +5",
+	"CriterionBuilds":     "Mindestens 50 Builds",
+	"CriterionBuildsHead": "Mindestens 5 Builds an HEAD",
+	"CriterionBroken":     `Nicht mehr als 1 "baut nicht" an HEAD`,
+	"CriterionURLs":       "Home-, Quell- und Bug-Report-URL angegeben",
+	"CriterionURLsOf":     "%d von 3",
+
+	// Project page
+	"PlusOne":         "+1",
+	"UnPlusOne":       "+1 zur&uuml;cknehmen",
+	"WontBuild":       "Baut nicht",
+	"ProjectOffline":  "Statistik vor&uuml;bergehend nicht verf&uuml;gbar, bitte versuchen Sie es sp&auml;ter noch einmal.",
+	"ProjectStale":    "Statistik vor&uuml;bergehend nicht verf&uuml;gbar, die Werte sind m&ouml;glicherweise veraltet.",
+	"ScoreHeading":    "Punkte:",
+	"Stats":           "Statistik",
+	"AtHead":          "An HEAD",
+	"ActivityHeading": "Aktivit&auml;t",
+	"BuildsCount":     "Builds",
+	"CommitsCount":    "Commits",
+	"PerWeek":         "(pro Woche)",
+	"RecentBuilds":    "Letzte Builds",
+	"RecentCommits":   "Letzte Commits",
+	"NoneYet":         "Noch keine",
+	"None":            "Keine",
+	"BrokenReports":   "Meldungen &bdquo;baut nicht&ldquo;",
+	"Reported":        "Gemeldet",
+	"Go":              "Go",
+	"Platform":        "Plattform",
+	"Error":           "Fehler",
+	"Status":          "Status",
+	"Save":            "Speichern",
+	"GoVersion":       "Go-Version:",
+	"GoVersionHelp":   "(z.B. die Ausgabe von <code>go version</code>)",
+	"OSArch":          "OS/Architektur:",
+	"OSArchHelp":      "(z.B. linux/amd64)",
+	"ErrorLabel":      "Fehler:",
+	"ReportBroken":    "Melden, dass es nicht baut",
+	"Reviews":         "Rezensionen",
+	"StarsFrom":       "von 5 Sternen aus",
+	"RatingsCount":    "Bewertungen",
+	"StarsCount":      "Sterne",
+	"NoRatings":       "Noch keine Bewertungen.",
+	"OwnerReplied":    "Antwort des Inhabers:",
+	"Reply":           "Antworten",
+	"FlagReview":      "Zur Moderation melden",
+	"NoReviews":       "Noch keine Rezensionen.",
+	"YourReview":      "Ihre Rezension",
+	"NoStars":         "Keine Bewertung",
+	"SaveReview":      "Rezension speichern",
+	"DeleteReview":    "Rezension l&ouml;schen",
+	"LogInToReview":   "<a href=\"/login\">Melden Sie sich an</a>, um dieses Projekt zu rezensieren.",
+	"Deliveries":      "Webhook-Zustellungen",
+	"Time":            "Zeit",
+	"Event":           "Ereignis",
+	"URL":             "URL",
+	"Attempt":         "Versuch",
+	"Result":          "Ergebnis",
+	"EmbedHeading":    "Einbetten",
+	"Badge":           "Plakette",
+	"BadgeAlt":        "Go-Widget-Punkte",
+	"Markdown":        "Markdown:",
+	"HTML":            "HTML:",
+	"BBCode":          "BBCode:",
+	"ImageHeading":    "Bild",
+	"ImageHelp":       "F&uuml;r Foren und E-Mail-Programme, die keine Skripte oder SVG erlauben.",
+	"OnGoWidget":      "auf Go-Widget",
+
+	// Search, and the admin pages for tags and reviews
+	"CategoryColumn": "Kategorie",
+	"TagsColumn":     "Tags",
+	"ProjectsColumn": "Projekte",
+	"NewTag":         "Neuer Tag:",
+	"Add":            "Hinzuf&uuml;gen",
+	"Merge":          "Zusammenf&uuml;hren",
+	"Into":           "in",
+	"SynonymFor":     "Synonym f&uuml;r",
+	"ReviewsIntro":   "Gemeldete Rezensionen stehen zuerst, danach die neuesten.",
+	"Author":         "Autor",
+	"Stars":          "Sterne",
+	"Date":           "Datum",
+	"Review":         "Rezension",
+	"ReplyLabel":     "Antwort:",
+	"Hidden":         "verborgen",
+	"Flagged":        "gemeldet",
+	"Hide":           "Verbergen",
+	"Show":           "Anzeigen",
+	"Dismiss":        "Verwerfen",
+	"Delete":         "L&ouml;schen",
+}
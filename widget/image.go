@@ -0,0 +1,236 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"image"
+	"image/png"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"appengine"
+)
+
+// Widgets are also available as a PNG image at /widget/image/{ID}.png, for
+// forums and mail clients which strip both scripts and SVG.  The image is
+// drawn pixel by pixel with a built in bitmap font, so it doesn't depend on
+// any fonts being installed.
+
+// glyphs is a 5x7 bitmap font.  Each glyph is a row of bits per line, with the
+// leftmost pixel in the 0x10 bit.  Lower case letters are drawn in upper case,
+// and characters without a glyph are drawn as '?'.  The umlauts are there for
+// the German messages.
+var glyphs = map[int][7]byte{
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'!':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04},
+	'"':  {0x0A, 0x0A, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'#':  {0x0A, 0x0A, 0x1F, 0x0A, 0x1F, 0x0A, 0x0A},
+	'%':  {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'&':  {0x0C, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0D},
+	'\'': {0x04, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'*':  {0x00, 0x04, 0x15, 0x0E, 0x15, 0x04, 0x00},
+	'+':  {0x00, 0x04, 0x04, 0x1F, 0x04, 0x04, 0x00},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0C, 0x04, 0x08},
+	'-':  {0x00, 0x00, 0x00, 0x1F, 0x00, 0x00, 0x00},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0x0C},
+	'/':  {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	'0':  {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1':  {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3':  {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4':  {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5':  {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6':  {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9':  {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	':':  {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x0C, 0x00},
+	';':  {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x04, 0x08},
+	'<':  {0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02},
+	'=':  {0x00, 0x00, 0x1F, 0x00, 0x1F, 0x00, 0x00},
+	'>':  {0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08},
+	'?':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+	'@':  {0x0E, 0x11, 0x01, 0x0D, 0x15, 0x15, 0x0E},
+	'A':  {0x0E, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'B':  {0x1E, 0x11, 0x11, 0x1E, 0x11, 0x11, 0x1E},
+	'C':  {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'D':  {0x1C, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1C},
+	'E':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'F':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x10},
+	'G':  {0x0E, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0F},
+	'H':  {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'I':  {0x0E, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0C},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'M':  {0x11, 0x1B, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0E, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'P':  {0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10, 0x10},
+	'Q':  {0x0E, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0D},
+	'R':  {0x1E, 0x11, 0x11, 0x1E, 0x14, 0x12, 0x11},
+	'S':  {0x0F, 0x10, 0x10, 0x0E, 0x01, 0x01, 0x1E},
+	'T':  {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0A, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0A},
+	'X':  {0x11, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x0A, 0x04, 0x04, 0x04, 0x04},
+	'Z':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1F},
+	'[':  {0x0E, 0x08, 0x08, 0x08, 0x08, 0x08, 0x0E},
+	']':  {0x0E, 0x02, 0x02, 0x02, 0x02, 0x02, 0x0E},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1F},
+	'|':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'~':  {0x00, 0x00, 0x08, 0x15, 0x02, 0x00, 0x00},
+	'Ä':  {0x0A, 0x00, 0x0E, 0x11, 0x1F, 0x11, 0x11},
+	'Ö':  {0x0A, 0x00, 0x0E, 0x11, 0x11, 0x11, 0x0E},
+	'Ü':  {0x0A, 0x00, 0x11, 0x11, 0x11, 0x11, 0x0E},
+}
+
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphSpacing = 1 // between characters and lines
+
+	imageScale   = 2 // every font pixel is drawn as a square this big
+	imageWidth   = 300
+	imagePadding = 6
+	lineHeight   = (glyphHeight + 2*glyphSpacing) * imageScale
+)
+
+// parseColor converts an HTML color of the form #RRGGBB.
+func parseColor(color string) image.RGBAColor {
+	c := image.RGBAColor{A: 0xFF}
+	if !validColor(color) {
+		return c
+	}
+	for i, v := range []*uint8{&c.R, &c.G, &c.B} {
+		n, _ := strconv.Btoui64(color[1+2*i:3+2*i], 16)
+		*v = uint8(n)
+	}
+	return c
+}
+
+// fillRect fills the rectangle from (x0, y0) up to but not including (x1, y1).
+func fillRect(m *image.RGBA, x0, y0, x1, y1 int, c image.Color) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			m.Set(x, y, c)
+		}
+	}
+}
+
+// textColumns returns how many characters of text fit in width pixels.
+func textColumns(width int) int {
+	return width / ((glyphWidth + glyphSpacing) * imageScale)
+}
+
+// drawText draws a single line of text with its top left corner at (x, y).
+func drawText(m *image.RGBA, x, y int, text string, c image.Color) {
+	for _, ch := range text {
+		glyph, ok := glyphs[unicode.ToUpper(ch)]
+		if !ok {
+			glyph = glyphs['?']
+		}
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(0x10>>uint(col)) == 0 {
+					continue
+				}
+				px, py := x+col*imageScale, y+row*imageScale
+				fillRect(m, px, py, px+imageScale, py+imageScale, c)
+			}
+		}
+		x += (glyphWidth + glyphSpacing) * imageScale
+	}
+}
+
+// fitText shortens text to fit in width pixels.
+func fitText(text string, width int) string {
+	if runes, max := []int(text), textColumns(width); len(runes) > max {
+		return string(runes[:max-3]) + "..."
+	}
+	return text
+}
+
+// renderImage draws the widget's name and key stats in its theme's colors.
+func renderImage(widget *Widget) image.Image {
+	colors := widget.currentTheme().Colors
+	loc := widget.loc()
+
+	var lines []string
+	if widget.Unavailable() {
+		lines = append(lines, loc.Text("ImageUnavailable"))
+	} else {
+		lines = append(lines,
+			fmt.Sprintf(loc.Text("ImageScore"), widget.Score()),
+			fmt.Sprintf(loc.Text("ImageRating"), loc.Number(int64(widget.Rating()))),
+			fmt.Sprintf(loc.Text("ImageLastBuild"), widget.CompileElapsed()),
+		)
+	}
+
+	title := lineHeight + 2*imagePadding
+	height := title + len(lines)*lineHeight + 2*imagePadding
+	m := image.NewRGBA(imageWidth, height)
+	inner := imageWidth - 2*imagePadding
+
+	border := parseColor(colors.Main.Border)
+	fillRect(m, 0, 0, imageWidth, height, border)
+	fillRect(m, 1, 1, imageWidth-1, title, parseColor(colors.Good.Background))
+	fillRect(m, 1, title, imageWidth-1, height-1, parseColor(colors.Main.Background))
+
+	drawText(m, imagePadding, imagePadding+glyphSpacing*imageScale, fitText(widget.Name, inner), parseColor(colors.Good.Text))
+	text := parseColor(colors.Main.Text)
+	for i, line := range lines {
+		y := title + imagePadding + i*lineHeight + glyphSpacing*imageScale
+		drawText(m, imagePadding, y, fitText(line, inner), text)
+	}
+	return m
+}
+
+func showImage(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 3 {
+		http.Error(w, "/widget/image/{widget}.png - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	widgethash := path[2]
+	if !strings.HasSuffix(widgethash, ".png") {
+		http.Error(w, "Images are only available as .png", http.StatusNotFound)
+		return
+	}
+	widgethash = strings.ToUpper(widgethash[:len(widgethash)-len(".png")])
+	if len(widgethash) != 32 {
+		http.Error(w, "Invalid widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusNotFound)
+		return
+	}
+	widget.Override(r)
+
+	varyLanguage(w)
+	setCacheHeaders(w, cachePublic, !widget.Unavailable() && !widget.Stale())
+	if notModified(w, r, widget.ETag("image")) {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := png.Encode(buf, renderImage(widget)); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
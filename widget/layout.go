@@ -0,0 +1,211 @@
+package widget
+
+import (
+	"os"
+	"strings"
+)
+
+// Owners choose which sections their embedded widget shows, in what order,
+// and whether it is laid out as the full table, a single line or a card.
+// Like the theme, both can be overridden for a single embed, e.g.
+// widget.js?layout=compact&sections=score,rating.
+
+// Layouts
+const (
+	layoutFull    = "full"
+	layoutCompact = "compact"
+	layoutCard    = "card"
+)
+
+var layouts = []string{layoutFull, layoutCompact, layoutCard}
+
+func validLayout(layout string) bool {
+	for _, l := range layouts {
+		if l == layout {
+			return true
+		}
+	}
+	return false
+}
+
+// widgetSections are the sections a widget can show.
+var widgetSections = []string{"score", "rating", "broken", "links", "builds", "commits", "sparkline"}
+
+// defaultSections are shown by widgets which haven't chosen any, and match
+// what the widget showed before sections could be chosen.
+var defaultSections = []string{"score", "rating", "broken", "links", "builds", "commits"}
+
+func validSection(section string) bool {
+	for _, s := range widgetSections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSections reads a list of sections separated by commas or spaces.
+// Sections listed more than once are only shown the first time.
+func ParseSections(list string) ([]string, os.Error) {
+	var sections []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Fields(strings.Replace(list, ",", " ", -1)) {
+		s = strings.ToLower(s)
+		if !validSection(s) {
+			return nil, os.NewError("unknown section: " + s)
+		}
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		sections = append(sections, s)
+	}
+	if len(sections) == 0 {
+		return nil, os.NewError("no sections given")
+	}
+	return sections, nil
+}
+
+// A widgetSection is one section of the widget, for templates.  The fields
+// are named so that they don't hide the widget's own.
+type widgetSection struct {
+	ScoreSection     bool
+	RatingSection    bool
+	BrokenSection    bool
+	LinksSection     bool
+	BuildsSection    bool
+	CommitsSection   bool
+	SparklineSection bool
+}
+
+func newWidgetSection(name string) *widgetSection {
+	s := new(widgetSection)
+	switch name {
+	case "score":
+		s.ScoreSection = true
+	case "rating":
+		s.RatingSection = true
+	case "broken":
+		s.BrokenSection = true
+	case "links":
+		s.LinksSection = true
+	case "builds":
+		s.BuildsSection = true
+	case "commits":
+		s.CommitsSection = true
+	case "sparkline":
+		s.SparklineSection = true
+	}
+	return s
+}
+
+// currentLayout returns the layout the widget is shown with.
+func (w *Widget) currentLayout() string {
+	if len(w.override.Layout) > 0 {
+		return w.override.Layout
+	}
+	if validLayout(w.Layout) {
+		return w.Layout
+	}
+	return layoutFull
+}
+
+// sectionNames returns the sections the widget is shown with, in order.
+func (w *Widget) sectionNames() []string {
+	if len(w.override.Sections) > 0 {
+		return w.override.Sections
+	}
+	if len(w.Sections) > 0 {
+		return w.Sections
+	}
+	return defaultSections
+}
+
+func (w *Widget) shows(section string) bool {
+	for _, s := range w.sectionNames() {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// ShownSections returns the sections the widget is shown with, in order.
+func (w *Widget) ShownSections() (sections []*widgetSection) {
+	for _, name := range w.sectionNames() {
+		sections = append(sections, newWidgetSection(name))
+	}
+	return
+}
+
+// ShowScore returns true if the score is shown.  In the full and card
+// layouts, it is part of the title.
+func (w *Widget) ShowScore() bool {
+	return w.shows("score")
+}
+
+// HasProjectSections returns true if the Project tab of the full layout has
+// anything in it.
+func (w *Widget) HasProjectSections() bool {
+	return w.shows("rating") || w.shows("broken") || w.shows("links") || w.shows("sparkline")
+}
+
+// HasStatSections returns true if the Builds tab of the full layout has
+// anything in it.
+func (w *Widget) HasStatSections() bool {
+	return w.shows("builds") || w.shows("commits")
+}
+
+// HasTabs returns true if the full layout needs both of its tabs.
+func (w *Widget) HasTabs() bool {
+	return w.HasProjectSections() && w.HasStatSections()
+}
+
+// SectionList returns the widget's sections, for editing.
+func (w *Widget) SectionList() string {
+	if len(w.Sections) > 0 {
+		return strings.Join(w.Sections, ", ")
+	}
+	return strings.Join(defaultSections, ", ")
+}
+
+// AllSections returns the names of every section, for help text.
+func (w *Widget) AllSections() string {
+	return strings.Join(widgetSections, ", ")
+}
+
+// LayoutOptions returns the layouts to choose from, for a select.
+func (w *Widget) LayoutOptions() (opts []*categoryOption) {
+	for _, l := range layouts {
+		opts = append(opts, &categoryOption{l, l == w.currentLayout()})
+	}
+	return
+}
+
+// A sparkBar is one week of the sparkline.
+type sparkBar struct {
+	Count  int
+	Height int // pixels
+}
+
+const sparkHeight = 16 // pixels
+
+// Sparkline returns the bars of the activity sparkline, oldest first.
+func (w *Widget) Sparkline() (bars []*sparkBar) {
+	if !w.populated {
+		w.populate()
+	}
+	max := 1
+	for _, n := range w.stats.Activity {
+		if n > max {
+			max = n
+		}
+	}
+	for _, n := range w.stats.Activity {
+		bars = append(bars, &sparkBar{
+			Count:  n,
+			Height: 1 + n*(sparkHeight-1)/max,
+		})
+	}
+	return
+}
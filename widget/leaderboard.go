@@ -1,85 +1,336 @@
 package widget
 
 import (
+	"bytes"
 	"fmt"
 	"http"
 	"os"
-	"template"
+	"strconv"
+	"strings"
 
 	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
 )
 
-var leaderBoardTemplate = ``+
-`<html>
-<head>
-	<title>Project Leader Board</title>
-{CSS}
-</head>
-<body>
-{Header}
-<h1>Project Leader Board</h1>
-<table class='leaderBoard'>
-<thead>
-	<tr>
-		<th></th>
-		<th>Project</th>
-		<th>Score</th>
-		<th>Rating</th>
-		<th></th>
-		<th></th>
-	</th>
-</thead>
-<tbody>
-{.repeated section Widget}
-	<tr>
-		<th class='topWidget left'></th>
-		<th><a href="{HomeURL|html}">{Name}</a></th>
-		<td class='right'>{CachedScore}/5</td>
-		<td class='right'>{CachedRating} (<a href="/hook/plusone/{ID}">+</a>)</td>
-		<td><a href="{SourceURL|html}">Source</a></td>
-		<td><a href="{BugURL|html}">Report a Bug</a></td>
-	</tr>
-{.end}
-</tbody>
-</table>
-</body>
-</html>
-`
+// A leaderBoardSort is one of the orders the leader board can be sorted in.
+type leaderBoardSort struct {
+	Name  string
+	Title string // message key, see i18n.go
+	Order []string
+}
+
+var leaderBoardSorts = []*leaderBoardSort{
+	{"score", "SortScore", []string{"-CachedScore", "-CachedRating"}},
+	{"rating", "SortRating", []string{"-CachedRating", "-CachedScore"}},
+	{"builds", "SortBuilds", []string{"-CachedBuildWeek", "-CachedScore"}},
+	{"commits", "SortCommits", []string{"-CachedCommitLast"}},
+	{"newest", "SortNewest", []string{"-Created"}},
+}
+
+func findLeaderBoardSort(name string) *leaderBoardSort {
+	for _, sort := range leaderBoardSorts {
+		if sort.Name == name {
+			return sort
+		}
+	}
+	return leaderBoardSorts[0]
+}
+
+// leaderBoardPage is the number of widgets shown on each page.
+const leaderBoardPage = 50
+
+// A LeaderBoardQuery selects a page of the leader board.  Every combination
+// of Sort, MinScore and Tag needs an index in index.yaml.
+type LeaderBoardQuery struct {
+	Sort     string
+	MinScore int
+	Tag      string
+	Cursor   string
+}
+
+// LoadLeaderBoard returns a page of the leader board and the cursor for the
+// next page, which is empty if this is the last page.
+func LoadLeaderBoard(ctx appengine.Context, q *LeaderBoardQuery) (widgets []*Widget, next string, err os.Error) {
+	query := datastore.NewQuery("Widget")
+	if q.MinScore > 0 {
+		query.Filter("ScoreTiers =", int64(q.MinScore))
+	}
+	if len(q.Tag) > 0 {
+		query.Filter("Tags =", q.Tag)
+	}
+	for _, order := range findLeaderBoardSort(q.Sort).Order {
+		query.Order(order)
+	}
+	if len(q.Cursor) > 0 {
+		cursor, err := datastore.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query.Start(cursor)
+	}
+
+	iter := query.Run(ctx)
+	for len(widgets) < leaderBoardPage {
+		w := new(Widget)
+		key, err := iter.Next(w)
+		if err == datastore.Done {
+			return widgets, "", nil
+		} else if err != nil {
+			return nil, "", err
+		}
+		w.ctx = ctx
+		w.key = key
+		widgets = append(widgets, w)
+	}
+
+	cursor, err := iter.Cursor()
+	if err != nil {
+		return nil, "", err
+	}
+	return widgets, cursor.String(), nil
+}
+
+// URL returns the address of the leader board page for the query.  The offset
+// is the number of widgets on the pages before it.
+func (q *LeaderBoardQuery) URL(offset int) string {
+	params := make(http.Values)
+	if q.Sort != leaderBoardSorts[0].Name {
+		params.Set("sort", q.Sort)
+	}
+	if q.MinScore > 0 {
+		params.Set("min", strconv.Itoa(q.MinScore))
+	}
+	if len(q.Cursor) > 0 {
+		params.Set("cursor", q.Cursor)
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	path := "/leaderboard"
+	if len(q.Tag) > 0 {
+		path += "/tag/" + http.URLEscape(q.Tag)
+	}
+	if len(params) == 0 {
+		return path
+	}
+	return path + "?" + params.Encode()
+}
+
+type sortOption struct {
+	Title    string
+	URL      string
+	Selected bool
+}
+
+type minScoreOption struct {
+	Title    string
+	Value    int
+	Selected bool
+}
 
 type leaderBoardData struct {
 	CSS string
 	Header string
+	T map[string]string
 	Widget []*Widget
+
+	Cloud    []*cloudTag
+	Sort     []*sortOption
+	SortName string
+	MinScore []*minScoreOption
+	Tag      string
+
+	Offset int
+	First  string
+	Next   string
+
+	Stale bool
+	Unavailable bool
+}
+
+// The last leader board that loaded successfully is kept in memcache, so that
+// there is something to show when the datastore is having problems.
+const lastLeaderBoardKey = "leaderboard:last"
+
+func loadLastTopWidgets(ctx appengine.Context) (widgets []*Widget, err os.Error) {
+	_, err = memcache.Gob.Get(ctx, lastLeaderBoardKey, &widgets)
+	for _, w := range widgets {
+		w.ctx = ctx
+		w.key = datastore.NewKey("Widget", w.ID, 0, nil)
+	}
+	return
+}
+
+func saveLastTopWidgets(ctx appengine.Context, widgets []*Widget) os.Error {
+	return memcache.Gob.Set(ctx, &memcache.Item{
+		Key: lastLeaderBoardKey,
+		Object: widgets,
+	})
 }
 
 func leaderBoard(w http.ResponseWriter, r *http.Request) {
 	var err os.Error
 	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
 
-	page, err := template.Parse(leaderBoardTemplate, nil)
-	if err != nil {
-		http.Error(w, err.String(), http.StatusInternalServerError)
+	q := &LeaderBoardQuery{
+		Sort: findLeaderBoardSort(r.FormValue("sort")).Name,
+		Tag: normalizeTag(r.FormValue("tag")),
+		Cursor: r.FormValue("cursor"),
+	}
+	if strings.HasPrefix(r.URL.Path, "/leaderboard/tag/") {
+		q.Tag = normalizeTag(r.URL.Path[len("/leaderboard/tag/"):])
+	} else if r.URL.Path != "/leaderboard" {
+		fourOhFour(w, r)
 		return
 	}
+	if len(q.Tag) > 0 {
+		// Synonyms show the leader board for the canonical tag
+		if tag, err := LoadTag(ctx, q.Tag); err == nil && len(tag.Canonical) > 0 {
+			q.Tag = tag.Canonical
+		}
+	}
+	q.MinScore, _ = strconv.Atoi(r.FormValue("min"))
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	if len(q.Cursor) == 0 || offset < 0 {
+		offset = 0
+	}
 
 	data := leaderBoardData{
 		CSS: commonCSS(),
-		Header: header(ctx),
+		Header: header(ctx, loc),
+		T: loc.Messages,
+		SortName: q.Sort,
+		Tag: q.Tag,
+		Offset: offset,
 	}
 
-	data.Widget, err = LoadTopWidgets(ctx)
-	if err != nil {
-		http.Error(w, err.String(), http.StatusInternalServerError)
-		return
+	for _, sort := range leaderBoardSorts {
+		opt := *q
+		opt.Sort, opt.Cursor = sort.Name, ""
+		data.Sort = append(data.Sort, &sortOption{
+			Title: loc.Text(sort.Title),
+			URL: opt.URL(0),
+			Selected: sort.Name == q.Sort,
+		})
+	}
+	for min := 0; min <= 5; min++ {
+		opt := &minScoreOption{
+			Title: fmt.Sprintf("%d/5", min),
+			Value: min,
+			Selected: min == q.MinScore,
+		}
+		if min == 0 {
+			opt.Title = loc.Text("AnyScore")
+		}
+		data.MinScore = append(data.MinScore, opt)
+	}
+
+	// Only the default first page is kept for when the datastore fails
+	isDefault := q.Sort == leaderBoardSorts[0].Name && q.MinScore == 0 && len(q.Tag) == 0 && len(q.Cursor) == 0
+
+	var next string
+	data.Widget, next, err = LoadLeaderBoard(ctx, q)
+	switch {
+	case err != nil && !isDefault:
+		ctx.Errorf("Leader board: %s", err)
+		countMetric(ctx, "leaderboard.error")
+		data.Widget = nil
+		data.Unavailable = true
+	case err != nil:
+		ctx.Errorf("Leader board: %s", err)
+		countMetric(ctx, "leaderboard.error")
+
+		data.Widget, err = loadLastTopWidgets(ctx)
+		if err != nil {
+			ctx.Infof("Leader board: no earlier leader board: %s", err)
+			data.Widget = nil
+			data.Unavailable = true
+		} else {
+			data.Stale = true
+		}
+	case isDefault:
+		if err := saveLastTopWidgets(ctx, data.Widget); err != nil {
+			ctx.Debugf("Leader board: cache: %s", err)
+		}
+	}
+
+	if err := markRated(ctx, r, data.Widget); err != nil {
+		ctx.Warningf("Leader board: rated: %s", err)
+	}
+	for _, widget := range data.Widget {
+		widget.locale = loc
 	}
 
+	if len(next) > 0 {
+		opt := *q
+		opt.Cursor = next
+		data.Next = opt.URL(offset + len(data.Widget))
+	}
+	if len(q.Cursor) > 0 {
+		opt := *q
+		opt.Cursor = ""
+		data.First = opt.URL(0)
+	}
+
+	if len(q.Tag) == 0 {
+		if data.Cloud, err = LoadTagCloud(ctx); err != nil {
+			ctx.Warningf("Leader board: tag cloud: %s", err)
+		}
+	}
+
+	// The page shows who is logged in and what they've rated, so it can only
+	// be cached by their browser.  It's tagged by its contents.
+	buf := bytes.NewBuffer(nil)
 	if len(r.FormValue("ids_only")) > 0 {
 		w.Header().Set("Content-Type", "text/plain")
 		for _, widget := range data.Widget {
-			fmt.Fprintln(w, widget.ID)
+			fmt.Fprintln(buf, widget.ID)
 		}
+	} else if err := renderPage(buf, loc, "leaderboard.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	varyLanguage(w)
+	setCacheHeaders(w, cachePrivate, !data.Unavailable && !data.Stale)
+	if notModified(w, r, Hashf("%s", buf.Bytes())) {
 		return
 	}
+	w.Write(buf.Bytes())
+}
+
+// A LeaderBoardSnapshot records the top widgets at a point in time.
+type LeaderBoardSnapshot struct {
+	Time    datastore.Time
+	ID      []string
+	Score   []int64
+	Rating  []int64
+}
+
+// LoadSnapshots returns the most recent leader board snapshots, newest first.
+func LoadSnapshots(ctx appengine.Context, limit int) (snaps []*LeaderBoardSnapshot, err os.Error) {
+	query := datastore.NewQuery("LeaderBoardSnapshot")
+	query.Order("-Time")
+	query.Limit(limit)
+
+	_, err = query.GetAll(ctx, &snaps)
+	return
+}
+
+func cronSnapshot(ctx appengine.Context, cursor string) (string, int, os.Error) {
+	widgets, err := LoadTopWidgets(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+
+	snap := &LeaderBoardSnapshot{
+		Time: now(),
+	}
+	for _, w := range widgets {
+		snap.ID = append(snap.ID, w.ID)
+		snap.Score = append(snap.Score, w.CachedScore)
+		snap.Rating = append(snap.Rating, w.CachedRating)
+	}
 
-	page.Execute(w, data)
+	_, err = datastore.Put(ctx, datastore.NewIncompleteKey("LeaderBoardSnapshot", nil), snap)
+	return "", len(widgets), err
 }
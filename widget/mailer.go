@@ -0,0 +1,86 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"smtp"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/mail"
+)
+
+// A Mail is a plain text email to be sent by a Mailer.
+type Mail struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// A Mailer sends email.  The sender address is up to the Mailer.
+type Mailer interface {
+	Send(m *Mail) os.Error
+}
+
+// An AppEngineMailer sends email with the App Engine mail API.
+type AppEngineMailer struct {
+	Context appengine.Context
+}
+
+func (m *AppEngineMailer) Send(msg *Mail) os.Error {
+	return mail.Send(m.Context, &mail.Message{
+		Sender:  mailSender(m.Context),
+		To:      msg.To,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+	})
+}
+
+func mailSender(ctx appengine.Context) string {
+	return fmt.Sprintf("Go-Widget <noreply@%s.appspotmail.com>", appengine.AppID(ctx))
+}
+
+// An SMTPMailer sends email through an SMTP server, for running outside of
+// App Engine.  Auth may be nil if the server doesn't require it.
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func (m *SMTPMailer) Send(msg *Mail) os.Error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, msg.To, message(m.From, msg))
+}
+
+// A FileMailer writes each email to a new file in Dir instead of sending it,
+// so that mail can be checked locally.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg *Mail) os.Error {
+	name := filepath.Join(m.Dir, fmt.Sprintf("%d.eml", time.Nanoseconds()))
+	return ioutil.WriteFile(name, message(m.From, msg), 0644)
+}
+
+// message formats msg as an RFC 822 message.
+func message(from string, msg *Mail) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "\r\n")
+	buf.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
+
+// newMailer returns the Mailer used by the app.
+var newMailer = func(ctx appengine.Context) Mailer {
+	return &AppEngineMailer{ctx}
+}
@@ -0,0 +1,77 @@
+package widget
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowidget-mail")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := &notifyData{
+		Widget: &Widget{
+			Name:  "gadget",
+			ID:    "0123456789ABCDEF0123456789ABCDEF",
+			Owner: "owner@example.com",
+		},
+		Rev:     "abc123",
+		Message: "undefined: foo",
+	}
+	msg, err := findNotification("buildfail").Mail(data.Widget.Owner, data)
+	if err != nil {
+		t.Fatalf("Mail: %s", err)
+	}
+
+	m := &FileMailer{Dir: dir, From: "Go-Widget <noreply@example.com>"}
+	if err := m.Send(msg); err != nil {
+		t.Fatalf("Send: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Send wrote %d files, want 1", len(files))
+	}
+	if got := filepath.Ext(files[0].Name); got != ".eml" {
+		t.Errorf("Send wrote %q, want a .eml file", files[0].Name)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	mail := string(got)
+
+	header := "From: Go-Widget <noreply@example.com>\r\n" +
+		"To: owner@example.com\r\n" +
+		"Subject: gadget failed to build\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n"
+	if !strings.HasPrefix(mail, header) {
+		t.Fatalf("Send wrote:\n%s\nwant it to start with:\n%s", mail, header)
+	}
+
+	body := mail[len(header):]
+	for _, line := range []string{
+		"A build of gadget failed.\r\n",
+		"Revision: abc123\r\n",
+		"undefined: foo\r\n",
+		"See http://go-widget.appspot.com/p/0123456789ABCDEF0123456789ABCDEF\r\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("body missing %q:\n%s", line, body)
+		}
+	}
+	if n, crlf := strings.Count(body, "\n"), strings.Count(body, "\r\n"); n != crlf {
+		t.Errorf("body has %d bare newlines, want lines ending in CRLF", n-crlf)
+	}
+}
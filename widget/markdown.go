@@ -0,0 +1,196 @@
+package widget
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The Markdown renderer understands a safe subset of Markdown: headings,
+// paragraphs, lists, block quotes, code blocks and spans, emphasis and links.
+// All text is escaped, and raw HTML is shown as text; links are only made for
+// http and https URLs.
+
+// escapeHTML escapes text for HTML.  Single quotes are always escaped, so that
+// the result is safe to embed with document.write.
+func escapeHTML(text string) string {
+	buf := bytes.NewBuffer(nil)
+	for _, c := range text {
+		switch c {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '"':
+			buf.WriteString("&#34;")
+		case '\'':
+			buf.WriteString("&#39;")
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+var (
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^) ]+)\)`)
+	mdStrong = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdEm     = regexp.MustCompile(`\*([^*]+)\*`)
+	mdHeader = regexp.MustCompile(`^(#+) +(.*)$`)
+	mdOList  = regexp.MustCompile(`^[0-9]+\. +`)
+)
+
+// markdownInline renders the inline markup in a single line of text.
+func markdownInline(text string) string {
+	buf := bytes.NewBuffer(nil)
+	for i, part := range strings.Split(text, "`", -1) {
+		if i%2 == 1 {
+			buf.WriteString("<code>" + escapeHTML(part) + "</code>")
+			continue
+		}
+		part = escapeHTML(part)
+		part = mdLink.ReplaceAllStringFunc(part, func(link string) string {
+			m := mdLink.FindStringSubmatch(link)
+			if !strings.HasPrefix(m[2], "http://") && !strings.HasPrefix(m[2], "https://") {
+				return m[1]
+			}
+			return `<a href="` + m[2] + `" rel="nofollow">` + m[1] + `</a>`
+		})
+		part = mdStrong.ReplaceAllString(part, "<strong>$1</strong>")
+		part = mdEm.ReplaceAllString(part, "<em>$1</em>")
+		buf.WriteString(part)
+	}
+	return buf.String()
+}
+
+// Markdown renders Markdown source as HTML.
+func Markdown(source string) string {
+	out := bytes.NewBuffer(nil)
+	lines := strings.Split(strings.Replace(source, "\r\n", "\n", -1), "\n", -1)
+
+	// The block currently being written: "p", "ul", "ol", "blockquote" or
+	// "pre" for an indented code block; fenced code blocks are handled as
+	// they are found.
+	var block string
+	closeBlock := func() {
+		switch block {
+		case "p":
+			out.WriteString("</p>\n")
+		case "ul", "ol":
+			out.WriteString("</li></" + block + ">\n")
+		case "blockquote":
+			out.WriteString("</p></blockquote>\n")
+		case "pre":
+			out.WriteString("</code></pre>\n")
+		}
+		block = ""
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			closeBlock()
+			out.WriteString("<pre><code>")
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				out.WriteString(escapeHTML(lines[i]) + "\n")
+			}
+			out.WriteString("</code></pre>\n")
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			if block != "pre" && block != "ul" && block != "ol" {
+				closeBlock()
+				block = "pre"
+				out.WriteString("<pre><code>")
+			}
+			if block == "pre" {
+				code := strings.TrimLeft(line, "\t")
+				if code == line {
+					code = line[4:]
+				}
+				out.WriteString(escapeHTML(code) + "\n")
+			} else {
+				out.WriteString(" " + markdownInline(trimmed))
+			}
+		case len(trimmed) == 0:
+			closeBlock()
+		case mdHeader.MatchString(trimmed):
+			closeBlock()
+			m := mdHeader.FindStringSubmatch(trimmed)
+			// Headings in a description are below the page's own
+			level := len(m[1]) + 2
+			if level > 6 {
+				level = 6
+			}
+			tag := "h" + strconv.Itoa(level)
+			text := strings.TrimSpace(strings.TrimRight(m[2], "#"))
+			out.WriteString("<" + tag + ">" + markdownInline(text) + "</" + tag + ">\n")
+		case trimmed == "---" || trimmed == "***" || trimmed == "___":
+			closeBlock()
+			out.WriteString("<hr/>\n")
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") || strings.HasPrefix(trimmed, "+ "):
+			if block == "ul" {
+				out.WriteString("</li>\n")
+			} else {
+				closeBlock()
+				block = "ul"
+				out.WriteString("<ul>\n")
+			}
+			out.WriteString("<li>" + markdownInline(trimmed[2:]))
+		case mdOList.MatchString(trimmed):
+			if block == "ol" {
+				out.WriteString("</li>\n")
+			} else {
+				closeBlock()
+				block = "ol"
+				out.WriteString("<ol>\n")
+			}
+			out.WriteString("<li>" + markdownInline(mdOList.ReplaceAllString(trimmed, "")))
+		case strings.HasPrefix(trimmed, ">"):
+			text := markdownInline(strings.TrimSpace(trimmed[1:]))
+			if block == "blockquote" {
+				out.WriteString(" " + text)
+			} else {
+				closeBlock()
+				block = "blockquote"
+				out.WriteString("<blockquote><p>" + text)
+			}
+		default:
+			switch block {
+			case "p", "ul", "ol", "blockquote":
+				// Continuation of the current block
+				out.WriteString("\n" + markdownInline(trimmed))
+			default:
+				closeBlock()
+				block = "p"
+				out.WriteString("<p>" + markdownInline(trimmed))
+			}
+		}
+	}
+	closeBlock()
+
+	return out.String()
+}
+
+// firstParagraph returns the plain text of the first paragraph of Markdown
+// source which isn't a heading, for use as a summary.
+func firstParagraph(source string) string {
+	var para []string
+	for _, line := range strings.Split(strings.Replace(source, "\r\n", "\n", -1), "\n", -1) {
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0 && len(para) > 0:
+			return strings.Join(para, " ")
+		case len(line) == 0, strings.HasPrefix(line, "#"), strings.HasPrefix(line, "="),
+			strings.HasPrefix(line, "-"), strings.HasPrefix(line, "```"):
+			para = nil
+		default:
+			para = append(para, line)
+		}
+	}
+	return strings.Join(para, " ")
+}
@@ -0,0 +1,57 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"strconv"
+
+	"appengine"
+	"appengine/memcache"
+)
+
+// metricNames lists the counters reported by /task/metrics.
+var metricNames = []string{
+	"stats.hit",
+	"stats.miss",
+	"stats.decode_error",
+	"stats.invalidate",
+	"stats.populate_error",
+	"stats.stale",
+	"leaderboard.error",
+}
+
+func metricKey(name string) string {
+	return "metric:" + name
+}
+
+// countMetric increments the named counter.  Counters live in memcache, so
+// they are approximate and start over whenever memcache is flushed.
+func countMetric(ctx appengine.Context, name string) {
+	if _, err := memcache.Increment(ctx, metricKey(name), 1, 0); err != nil {
+		ctx.Debugf("Metric %s: %s", name, err)
+	}
+}
+
+// readMetric returns the current value of the named counter.
+func readMetric(ctx appengine.Context, name string) int64 {
+	item, err := memcache.Get(ctx, metricKey(name))
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.Atoi64(string(item.Value))
+	return value
+}
+
+func taskMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	w.Header().Set("Content-Type", "text/plain")
+	for _, name := range metricNames {
+		fmt.Fprintf(w, "%-24s %d\n", name, readMetric(ctx, name))
+	}
+
+	hit, miss := readMetric(ctx, "stats.hit"), readMetric(ctx, "stats.miss")
+	if total := hit + miss; total > 0 {
+		fmt.Fprintf(w, "%-24s %.1f%%\n", "stats.hit_rate", 100*float64(hit)/float64(total))
+	}
+}
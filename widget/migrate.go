@@ -0,0 +1,344 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"strconv"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/taskqueue"
+)
+
+// A Migration upgrades every entity of one kind, a batch at a time.  Each
+// batch is processed by its own task, so a migration can be interrupted and
+// picks up where it left off.
+type Migration struct {
+	Name    string
+	Version int
+	Kind    string
+
+	// Apply is called for each entity of Kind and reports whether the entity
+	// needed to be changed.  If dryRun is set, nothing should be written.
+	Apply func(ctx appengine.Context, key *datastore.Key, props datastore.Map, dryRun bool) (changed bool, err os.Error)
+}
+
+// migrationBatch is the number of entities processed by each task.
+const migrationBatch = 20
+
+var migrations []*Migration
+
+// registerMigration makes a migration available to /task/upgrade.  It should
+// only be called from init.
+func registerMigration(m *Migration) {
+	if findMigration(m.Name) != nil {
+		panic("duplicate migration: " + m.Name)
+	}
+	migrations = append(migrations, m)
+}
+
+func findMigration(name string) *Migration {
+	for _, m := range migrations {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// MigrationStatus records the progress of one run of a migration.  Dry runs
+// are recorded separately, so they never mark a migration as done.
+type MigrationStatus struct {
+	Name    string
+	Version int
+	DryRun  bool
+
+	Cursor    string
+	Batches   int64
+	Processed int64
+	Changed   int64
+	Failed    int64
+	LastError string
+
+	Started  datastore.Time
+	Updated  datastore.Time
+	Finished datastore.Time
+}
+
+func migrationKey(name string, version int, dryRun bool) *datastore.Key {
+	id := fmt.Sprintf("%s:v%d", name, version)
+	if dryRun {
+		id += ":dry"
+	}
+	return datastore.NewKey("Migration", id, 0, nil)
+}
+
+func LoadMigrationStatus(ctx appengine.Context, m *Migration, dryRun bool) (status *MigrationStatus, err os.Error) {
+	status = new(MigrationStatus)
+	err = datastore.Get(ctx, migrationKey(m.Name, m.Version, dryRun), status)
+	return
+}
+
+func (s *MigrationStatus) Commit(ctx appengine.Context) (err os.Error) {
+	s.Updated = now()
+	_, err = datastore.Put(ctx, migrationKey(s.Name, s.Version, s.DryRun), s)
+	return
+}
+
+func (s *MigrationStatus) Done() bool {
+	return s.Finished > 0
+}
+
+func (s *MigrationStatus) StartDate() string {
+	return timestr(s.Started)
+}
+
+func (s *MigrationStatus) UpdateDate() string {
+	return timestr(s.Updated)
+}
+
+// errStaleBatch is returned when a batch has already been processed, by an
+// earlier attempt at its task or by a run which has since been restarted.
+var errStaleBatch = os.NewError("batch already processed")
+
+// commitAndQueue records the status and, unless the migration has finished,
+// adds the task for its next batch.  It must be called in a transaction, so
+// that the next batch is only queued if the progress of this one is saved.
+// Transactional tasks can't be named, so each task carries the run and batch
+// it is for, and taskMigrate drops the ones which are stale.
+func (s *MigrationStatus) commitAndQueue(tc appengine.Context) os.Error {
+	if err := s.Commit(tc); err != nil {
+		return err
+	}
+	if s.Done() {
+		return nil
+	}
+	task := taskqueue.NewPOSTTask("/task/migrate", http.Values{
+		"name":    {s.Name},
+		"dry_run": {strconv.Btoa(s.DryRun)},
+		"started": {strconv.Itoa64(int64(s.Started))},
+		"batch":   {strconv.Itoa64(s.Batches)},
+	})
+	_, err := taskqueue.Add(tc, task, "default")
+	return err
+}
+
+// StartMigration resets the status of the migration and queues its first
+// batch.  A migration which has already finished is only run again if force
+// is set.
+func StartMigration(ctx appengine.Context, m *Migration, dryRun, force bool) (status *MigrationStatus, err os.Error) {
+	status, err = LoadMigrationStatus(ctx, m, dryRun)
+	switch {
+	case err == datastore.ErrNoSuchEntity:
+	case err != nil:
+		return nil, err
+	case status.Done() && !force:
+		return status, nil
+	}
+
+	status = &MigrationStatus{
+		Name:    m.Name,
+		Version: m.Version,
+		DryRun:  dryRun,
+		Started: now(),
+	}
+	err = datastore.RunInTransaction(ctx, func(tc appengine.Context) os.Error {
+		return status.commitAndQueue(tc)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func taskUpgrade(out http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	var (
+		name   = r.FormValue("name")
+		dryRun = len(r.FormValue("dry_run")) > 0
+		force  = len(r.FormValue("force")) > 0
+	)
+
+	todo := migrations
+	if len(name) > 0 {
+		m := findMigration(name)
+		if m == nil {
+			http.Error(out, "Unknown migration: "+name, http.StatusBadRequest)
+			return
+		}
+		todo = []*Migration{m}
+	}
+
+	var failed bool
+	for _, m := range todo {
+		status, err := StartMigration(ctx, m, dryRun, force)
+		if err != nil {
+			ctx.Errorf("Migration %s: start: %s", m.Name, err)
+			failed = true
+			continue
+		}
+		if status.Done() {
+			ctx.Infof("Migration %s: already finished", m.Name)
+		}
+	}
+
+	if failed {
+		http.Error(out, "Some migrations failed to start", http.StatusInternalServerError)
+		return
+	}
+
+	if len(r.FormValue("redirect")) > 0 {
+		http.Redirect(out, r, "/admin/migrations", http.StatusFound)
+		return
+	}
+
+	out.Header().Set("Content-Type", "text/plain")
+	for _, m := range todo {
+		fmt.Fprintf(out, "Migration: Queued %s v%d\n", m.Name, m.Version)
+	}
+}
+
+// taskMigrate processes a single batch of a migration.  Failures to read or
+// record progress are reported to the task queue, which will retry the batch.
+func taskMigrate(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	m := findMigration(r.FormValue("name"))
+	if m == nil {
+		// Don't let the task queue retry this forever
+		ctx.Errorf("Migration: unknown migration %q", r.FormValue("name"))
+		return
+	}
+	dryRun, _ := strconv.Atob(r.FormValue("dry_run"))
+	started, _ := strconv.Atoi64(r.FormValue("started"))
+	batch, _ := strconv.Atoi64(r.FormValue("batch"))
+
+	status, err := LoadMigrationStatus(ctx, m, dryRun)
+	if err != nil {
+		http.Error(w, "Status: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+	if status.Done() || int64(status.Started) != started || status.Batches != batch {
+		ctx.Infof("Migration %s: dropping stale batch %d", m.Name, batch)
+		return
+	}
+
+	query := datastore.NewQuery(m.Kind)
+	if len(status.Cursor) > 0 {
+		cursor, err := datastore.DecodeCursor(status.Cursor)
+		if err != nil {
+			http.Error(w, "Cursor: "+err.String(), http.StatusInternalServerError)
+			return
+		}
+		query.Start(cursor)
+	}
+	iter := query.Run(ctx)
+
+	var count int
+	for count = 0; count < migrationBatch; count++ {
+		props := make(datastore.Map)
+		key, err := iter.Next(props)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			http.Error(w, "Next: "+err.String(), http.StatusInternalServerError)
+			return
+		}
+
+		changed, err := m.Apply(ctx, key, props, dryRun)
+		status.Processed++
+		if err != nil {
+			ctx.Warningf("Migration %s: %s: %s", m.Name, key, err)
+			status.Failed++
+			status.LastError = fmt.Sprintf("%s: %s", key, err)
+			continue
+		}
+		if changed {
+			status.Changed++
+		}
+	}
+
+	cursor, err := iter.Cursor()
+	if err != nil {
+		http.Error(w, "Cursor: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+	status.Cursor = cursor.String()
+	status.Batches++
+
+	if count < migrationBatch {
+		status.Finished = now()
+	}
+
+	// Check again that this batch hasn't been recorded, now that another
+	// attempt at it can't record it at the same time
+	err = datastore.RunInTransaction(ctx, func(tc appengine.Context) os.Error {
+		current, err := LoadMigrationStatus(tc, m, dryRun)
+		if err != nil {
+			return err
+		}
+		if current.Started != status.Started || current.Batches != batch {
+			return errStaleBatch
+		}
+		return status.commitAndQueue(tc)
+	}, nil)
+	if err == errStaleBatch {
+		ctx.Infof("Migration %s: dropping stale batch %d", m.Name, batch)
+		return
+	} else if err != nil {
+		http.Error(w, "Commit: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+	ctx.Infof("Migration %s: batch %d, %d processed", m.Name, status.Batches, status.Processed)
+}
+
+type migrationRun struct {
+	Name    string
+	Version int
+	Status  *MigrationStatus
+}
+
+type migrationsData struct {
+	CSS    string
+	Header string
+	Run    []*migrationRun
+}
+
+func adminMigrations(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
+
+	data := migrationsData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+	}
+
+	for _, m := range migrations {
+		for _, dryRun := range []bool{false, true} {
+			run := &migrationRun{
+				Name:    m.Name,
+				Version: m.Version,
+			}
+			status, err := LoadMigrationStatus(ctx, m, dryRun)
+			switch {
+			case err == nil:
+				run.Status = status
+			case err == datastore.ErrNoSuchEntity:
+				if dryRun {
+					// Only list dry runs which have actually happened
+					continue
+				}
+			default:
+				http.Error(w, err.String(), http.StatusInternalServerError)
+				return
+			}
+			data.Run = append(data.Run, run)
+		}
+	}
+
+	if err := renderPage(w, loc, "migrations.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
@@ -6,113 +6,50 @@ import (
 	"os"
 	"regexp"
 	"strings"
-	"template"
 
 	"appengine"
+	"appengine/user"
 )
 
-var myWidgetTemplate = ``+
-`<html>
-<head>
-	<title>My Widgets</title>
-{CSS}
-</head>
-<body>
-{Header}
-<h1>My Widgets</h1>
-{.repeated section Widget}
-<hr/>
-<h2>{Name}</h2>
-<h3>Embed:</h3>
-<pre>
-&lt;script language="javascript" type="text/javascript"
-	source="http://go-widget.appspot.com/widget/show/{ID}/widget.js">
-&lt;/script>
-&lt;noscript>
-&lt;a href="http://go-widget.appspot.com/widget/show/{ID}">{Name}&lt;/a>
-&lt;/noscript>
-</pre>
-<h3>Rating:</h3>
-<ol>
-<li>Rated at least +5 (Current: {Rating})</li>
-<li>At least 50 compiles (Current: {CompileTotal})</li>
-<li>At least 5 compile at HEAD (Current: {CompileCheckin})`+/*TODO(kevlar): since Go release*/`
-<li>No more than 1 "won't build" at HEAD (Current: {Broken})</li>
-<li>Set Home, Source, and Bug Report URLs</li>
-</ol>
-<h3>URLs</h3>
-<form method="post" action="/widget/update/{ID}">
-<table>
-<tr><td>Home:</td>
-<td><input name="home" type="text" size="100" value="{HomeURL|html}"/></td></tr>
-<tr><td>Source:</td>
-<td><input name="source" type="text" size="100" value="{SourceURL|html}"/></td></tr>
-<tr><td>Create Bug:</td>
-<td><input name="bug" type="text" size="100" value="{BugURL|html}"/></td></tr>
-</table>
-<input type="submit" value="Update"/>
-</form>
-<h3>Hooks</h3>
-Commit Hook URL: <pre>http://go-widget.appspot.com/hook/commit/{ID}</pre>
-Makefile:
-<pre>
---- %< ---
-success :
-	@curl -s "http://go-widget.appspot.com/hook/compile/{ID}" >/dev/null
---- %< ---
-</pre>
-
-<!--
-
-Primary Color: (green)
-28DE5F	40A65F	0D9035	5CEE88	83EEA4
-Secondary Color A: (blue)
-2AAFCE	3F899B	0E6F86	5DCDE7	82D4E7
-Secondary Color B: (orange)
-FF962F	BF844A	A65A0F	FFB063	FFC58C
-Complementary Color: (red)
-FF4B2F	BF5A4A	A6240F	FF7863	FF9C8C
-(accent, saturated, dark, pastel, light)
-
--->
-
-{ExecuteString}
-
-{.end}
-<form method="post" action="/widget/add">
-	<input name="name" />
-	<input type="submit" value="add" />
-</form>
-</body>
-</html>
-`
-
 type myWidgetData struct {
 	CSS string
 	Header string
+	T map[string]string
+	Feed string
+	Profile *Profile
 	Widget []*Widget
 }
 
 func myWidgets(w http.ResponseWriter, r *http.Request) {
 	var err os.Error
 	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
+
+	data := myWidgetData{
+		CSS: commonCSS(),
+		Header: header(ctx, loc),
+		T: loc.Messages,
+	}
 
-	page, err := template.Parse(myWidgetTemplate, nil)
+	data.Widget, err = LoadWidgets(ctx)
 	if err != nil {
 		http.Error(w, err.String(), http.StatusInternalServerError)
 		return
 	}
-
-	data := myWidgetData{
-		CSS: commonCSS(),
-		Header: header(ctx),
+	for _, widget := range data.Widget {
+		widget.locale = loc
 	}
 
-	data.Widget, err = LoadWidgets(ctx)
+	profile, err := LoadProfile(ctx, user.Current(ctx).Email)
 	if err != nil {
 		http.Error(w, err.String(), http.StatusInternalServerError)
 		return
 	}
+	if data.Feed, err = profile.Feed(ctx); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	data.Profile = profile
 
 	if len(r.FormValue("ids_only")) > 0 {
 		w.Header().Set("Content-Type", "text/plain")
@@ -122,7 +59,9 @@ func myWidgets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page.Execute(w, data)
+	if err := renderPage(w, loc, "my_widgets.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
 }
 
 // TODO(kevlar): Move to init
@@ -160,14 +99,16 @@ func addWidget(w http.ResponseWriter, r *http.Request) {
 func showWidget(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
 
-	_ = ctx
-
 	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
 	if cnt := len(path); cnt < 3 || cnt > 4 {
-		http.Error(w, "ID required, widget.js optional", http.StatusBadRequest)
+		http.Error(w, "ID required, widget.js, widget.html or widget.json optional", http.StatusBadRequest)
 		return
 	}
 	nojs := len(path) == 3
+	embed := "widget.js"
+	if len(path) == 4 {
+		embed = path[3]
+	}
 
 	widgethash := path[2]
 	if len(widgethash) != 32 {
@@ -181,6 +122,27 @@ func showWidget(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := markRated(ctx, r, []*Widget{widget}); err != nil {
+		ctx.Warningf("Show: rated: %s", err)
+	}
+	widget.Override(r)
+
+	// Whether the viewer has rated it is personal
+	varyLanguage(w)
+	setCacheHeaders(w, cachePrivate, !widget.Unavailable() && !widget.Stale())
+	if notModified(w, r, widget.ETag(fmt.Sprintf("%s|nojs=%v", embed, nojs))) {
+		return
+	}
+
+	switch embed {
+	case "widget.html":
+		writeWidgetFrame(w, widget)
+		return
+	case "widget.json":
+		writeWidgetJSON(w, widget)
+		return
+	}
+
 	if nojs {
 		fmt.Fprintf(w, "<html><head><title>"+widget.Name+"</title></head><body>\n")
 		fmt.Fprintf(w, "<script language='javascript' type='text/javascript'>\n")
@@ -241,10 +203,32 @@ func updateWidget(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !widget.CanEdit() {
+		http.Error(w, "Only the owner can change this widget", http.StatusForbidden)
+		return
+	}
+
 	widget.BugURL = bug
 	widget.HomeURL = home
 	widget.SourceURL = source
 
+	widget.Summary = cleanSummary(r.FormValue("summary"))
+	widget.EmbedSummary = len(r.FormValue("embed_summary")) > 0
+	description := strings.Replace(r.FormValue("description"), "\r\n", "\n", -1)
+	if len(description) > maxDescription {
+		http.Error(w, fmt.Sprintf("Description is too long: %d bytes, at most %d", len(description), maxDescription), http.StatusBadRequest)
+		return
+	}
+	widget.Description = []byte(description)
+
+	if category := r.FormValue("category"); len(category) == 0 || validCategory(category) {
+		widget.Category = category
+	}
+	if widget.Tags, err = ParseTags(ctx, r.FormValue("tags")); err != nil {
+		http.Error(w, "Error parsing tags: " + err.String(), http.StatusInternalServerError)
+		return
+	}
+
 	err = widget.Commit()
 	if err != nil {
 		http.Error(w, "Error comitting: " + err.String(), http.StatusBadRequest)
@@ -0,0 +1,175 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"os"
+	"template"
+
+	"appengine"
+	"appengine/taskqueue"
+)
+
+// Owners are notified by email of events on their widgets as they happen,
+// unless they have turned the notifications off in their profile.  Mail is
+// sent from a task, so that hooks don't wait for it.
+
+// A notification is an event an owner can be emailed about.
+type notification struct {
+	Event   string
+	Subject string
+	Body    string
+
+	// Enabled reports whether the owner wants the notification.
+	Enabled func(p *Profile) bool
+}
+
+var notifications = []*notification{
+	{
+		Event:   "broken",
+		Subject: "{.section Widget}{Name} was reported broken{.end}",
+		Body: `{.section Widget}Someone reported that {Name} won't build.
+{.section GoVersion}
+Go version: {@}
+{.end}{.section Platform}
+Platform: {@}
+{.end}{.section Message}
+{@}
+{.end}
+There are {Broken} open won't build reports since the last commit.
+See http://go-widget.appspot.com/p/{ID}
+{.end}`,
+		Enabled: (*Profile).NotifyBroken,
+	},
+	{
+		Event:   "buildfail",
+		Subject: "{.section Widget}{Name} failed to build{.end}",
+		Body: `{.section Widget}A build of {Name} failed.
+{.section Rev}
+Revision: {@}
+{.end}{.section Message}
+{@}
+{.end}
+See http://go-widget.appspot.com/p/{ID}
+{.end}`,
+		Enabled: (*Profile).NotifyBuildFail,
+	},
+}
+
+func findNotification(event string) *notification {
+	for _, n := range notifications {
+		if n.Event == event {
+			return n
+		}
+	}
+	return nil
+}
+
+type notifyData struct {
+	Widget    *Widget
+	Rev       string
+	Message   string
+	GoVersion string
+	Platform  string
+}
+
+// QueueNotify queues the notification of an event on a widget.  The name
+// identifies the event, so that a hook which is retried only notifies once.
+func QueueNotify(ctx appengine.Context, event, name string, data *notifyData) os.Error {
+	task := taskqueue.NewPOSTTask("/task/notify", http.Values{
+		"event":     {event},
+		"widget":    {data.Widget.ID},
+		"rev":       {data.Rev},
+		"message":   {data.Message},
+		"goversion": {data.GoVersion},
+		"platform":  {data.Platform},
+	})
+	task.Name = "notify-" + event + "-" + name
+	if _, err := taskqueue.Add(ctx, task, "default"); err != nil && err != taskqueue.ErrTaskAlreadyAdded {
+		return err
+	}
+	return nil
+}
+
+// Notify emails the owner of the widget about an event, if they want it.
+func Notify(ctx appengine.Context, n *notification, data *notifyData) os.Error {
+	owner := data.Widget.Owner
+	profile, err := LoadProfile(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if !n.Enabled(profile) {
+		ctx.Debugf("Notify: %s has %s notifications off", owner, n.Event)
+		return nil
+	}
+
+	msg, err := n.Mail(owner, data)
+	if err != nil {
+		return err
+	}
+	return newMailer(ctx).Send(msg)
+}
+
+// Mail returns the email notifying an owner of the event.
+func (n *notification) Mail(owner string, data *notifyData) (*Mail, os.Error) {
+	execute := func(text string) (string, os.Error) {
+		page, err := template.Parse(text, nil)
+		if err != nil {
+			return "", err
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := page.Execute(buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	subject, err := execute(n.Subject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := execute(n.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mail{
+		To:      []string{owner},
+		Subject: subject,
+		Body:    body,
+	}, nil
+}
+
+// taskNotify sends the notification queued by QueueNotify.
+func taskNotify(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	n := findNotification(r.FormValue("event"))
+	if n == nil {
+		http.Error(w, "Unknown event: "+r.FormValue("event"), http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, r.FormValue("widget"))
+	if err != nil {
+		// Don't retry notifications for deleted widgets
+		ctx.Warningf("Notify: widget %s: %s", r.FormValue("widget"), err)
+		return
+	}
+
+	data := &notifyData{
+		Widget:    widget,
+		Rev:       r.FormValue("rev"),
+		Message:   r.FormValue("message"),
+		GoVersion: r.FormValue("goversion"),
+		Platform:  r.FormValue("platform"),
+	}
+	if err := Notify(ctx, n, data); err != nil {
+		ctx.Errorf("Notify: %s for %s: %s", n.Event, widget.ID, err)
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "Notified %s of %s", widget.Owner, n.Event)
+}
@@ -0,0 +1,172 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"json"
+	"strconv"
+	"strings"
+	"template"
+
+	"appengine"
+)
+
+// Project pages are oEmbed (http://oembed.com/) resources, so that pasting a
+// link to one into a wiki or chat tool which supports oEmbed shows the widget.
+// The rich response's html is the widget itself, in the full layout if it
+// fits in the consumer's maxwidth and maxheight and the compact one if not.
+
+// Sizes of the widget layouts, in pixels, including their margins.
+const (
+	oembedFullWidth     = 310
+	oembedFullHeight    = 160
+	oembedCompactWidth  = 300
+	oembedCompactHeight = 24
+
+	oembedCacheAge = 300 // seconds
+)
+
+var oembedXMLTemplate = template.MustParse(``+
+	`<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<oembed>
+	<version>1.0</version>
+	<type>rich</type>
+	<provider_name>Go-Widget</provider_name>
+	<provider_url>{ProviderURL|html}</provider_url>
+	<title>{Title|html}</title>
+	<html>{HTML|html}</html>
+	<width>{Width}</width>
+	<height>{Height}</height>
+	<cache_age>{CacheAge}</cache_age>
+</oembed>
+`, nil)
+
+type oembedResponse struct {
+	ProviderURL string
+	Title       string
+	HTML        string
+	Width       int
+	Height      int
+	CacheAge    int
+}
+
+// OEmbedJSON returns the URL of the widget's oEmbed JSON, for discovery.
+func (w *Widget) OEmbedJSON() string {
+	return siteURL + "/oembed?url=" + http.URLEscape(siteURL+"/p/"+w.ID) + "&format=json"
+}
+
+// OEmbedXML returns the URL of the widget's oEmbed XML, for discovery.
+func (w *Widget) OEmbedXML() string {
+	return siteURL + "/oembed?url=" + http.URLEscape(siteURL+"/p/"+w.ID) + "&format=xml"
+}
+
+// oembedWidgetID returns the ID of the widget a URL shows, or "" if it isn't
+// the URL of a project page or widget on this site.
+func oembedWidgetID(r *http.Request, raw string) string {
+	url, err := http.ParseURL(raw)
+	if err != nil {
+		return ""
+	}
+	if scheme := strings.ToLower(url.Scheme); scheme != "http" && scheme != "https" {
+		return ""
+	}
+	if host := strings.ToLower(url.Host); host != strings.ToLower(r.Host) && siteURL != "http://"+host {
+		return ""
+	}
+
+	path := strings.Split(strings.Trim(url.Path, "/"), "/", -1)
+	switch {
+	case len(path) == 2 && path[0] == "p":
+		return strings.ToUpper(path[1])
+	case len(path) >= 3 && path[0] == "widget" && path[1] == "show":
+		return strings.ToUpper(path[2])
+	}
+	return ""
+}
+
+func oembed(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "xml" {
+		http.Error(w, "Unsupported format: "+format, http.StatusNotImplemented)
+		return
+	}
+
+	widgethash := oembedWidgetID(r, r.FormValue("url"))
+	if len(widgethash) != 32 {
+		http.Error(w, "Not a project URL: "+r.FormValue("url"), http.StatusNotFound)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusNotFound)
+		return
+	}
+
+	// Zero means no limit
+	maxwidth, _ := strconv.Atoi(r.FormValue("maxwidth"))
+	maxheight, _ := strconv.Atoi(r.FormValue("maxheight"))
+
+	resp := &oembedResponse{
+		ProviderURL: siteURL,
+		Title:       widget.Name,
+		Width:       oembedFullWidth,
+		Height:      oembedFullHeight,
+		CacheAge:    oembedCacheAge,
+	}
+	widget.locale = localeFor(r)
+	widget.override.Layout = layoutFull
+	if (maxwidth > 0 && maxwidth < oembedFullWidth) || (maxheight > 0 && maxheight < oembedFullHeight) {
+		widget.override.Layout = layoutCompact
+		resp.Width, resp.Height = oembedCompactWidth, oembedCompactHeight
+		if maxwidth > 0 && maxwidth < resp.Width {
+			resp.Width = maxwidth
+		}
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	varyLanguage(w)
+	setCacheHeaders(w, cachePublic, !widget.Unavailable() && !widget.Stale())
+	if notModified(w, r, widget.ETag(fmt.Sprintf("oembed|%s|%d|%d", format, resp.Width, resp.Height))) {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := widget.Execute(buf); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	// The links in the widget are relative to the site, but the consumer
+	// embeds the HTML in its own pages
+	resp.HTML = strings.Replace(buf.String(), `href="/`, `href="`+siteURL+`/`, -1)
+
+	if format == "xml" {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		oembedXMLTemplate.Execute(w, resp)
+		return
+	}
+
+	out, err := json.Marshal(map[string]interface{}{
+		"version":       "1.0",
+		"type":          "rich",
+		"provider_name": "Go-Widget",
+		"provider_url":  resp.ProviderURL,
+		"title":         resp.Title,
+		"html":          resp.HTML,
+		"width":         resp.Width,
+		"height":        resp.Height,
+		"cache_age":     resp.CacheAge,
+	})
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(out)
+}
@@ -0,0 +1,151 @@
+package widget
+
+import (
+	"crypto/rand"
+	"fmt"
+	"http"
+	"os"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+)
+
+// A Profile holds the settings of a user.
+type Profile struct {
+	Email string
+
+	// FeedToken identifies the user's private activity feed, since feed
+	// readers can't log in.
+	FeedToken string
+
+	// Notifications are on unless the user turns them off, so that the zero
+	// values are the defaults.
+	MuteBroken    bool
+	MuteBuildFail bool
+	Digest        string
+
+	// TimeZone is the time zone times are shown in, or "" for the browser's.
+	TimeZone string
+}
+
+// Digest frequencies; the empty string means digestWeekly.
+const (
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+	digestNever  = "never"
+)
+
+// DigestFrequency returns how often the user wants a digest.
+func (p *Profile) DigestFrequency() string {
+	switch p.Digest {
+	case digestDaily, digestNever:
+		return p.Digest
+	}
+	return digestWeekly
+}
+
+func (p *Profile) NotifyBroken() bool    { return !p.MuteBroken }
+func (p *Profile) NotifyBuildFail() bool { return !p.MuteBuildFail }
+
+func (p *Profile) DigestDaily() bool  { return p.DigestFrequency() == digestDaily }
+func (p *Profile) DigestWeekly() bool { return p.DigestFrequency() == digestWeekly }
+func (p *Profile) DigestNever() bool  { return p.DigestFrequency() == digestNever }
+
+func profileKey(email string) *datastore.Key {
+	return datastore.NewKey("Profile", email, 0, nil)
+}
+
+// LoadProfile returns the profile for the given user.  Users who haven't saved
+// any settings get a new profile with the defaults.
+func LoadProfile(ctx appengine.Context, email string) (profile *Profile, err os.Error) {
+	profile = new(Profile)
+	err = datastore.Get(ctx, profileKey(email), profile)
+	if err == datastore.ErrNoSuchEntity {
+		profile = &Profile{
+			Email: email,
+		}
+		err = nil
+	}
+	return
+}
+
+func (p *Profile) Commit(ctx appengine.Context) (err os.Error) {
+	_, err = datastore.Put(ctx, profileKey(p.Email), p)
+	return
+}
+
+// newToken returns a random token which is safe to use in a URL.
+func newToken() (string, os.Error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", buf), nil
+}
+
+// Feed returns the address of the user's private feed, creating the token for
+// it if necessary.
+func (p *Profile) Feed(ctx appengine.Context) (string, os.Error) {
+	if len(p.FeedToken) == 0 {
+		token, err := newToken()
+		if err != nil {
+			return "", err
+		}
+		p.FeedToken = token
+		if err := p.Commit(ctx); err != nil {
+			return "", err
+		}
+	}
+	return "/feed/user/" + p.FeedToken + ".atom", nil
+}
+
+// LoadProfileByFeed returns the profile with the given feed token.
+func LoadProfileByFeed(ctx appengine.Context, token string) (*Profile, os.Error) {
+	query := datastore.NewQuery("Profile")
+	query.Filter("FeedToken =", token)
+	query.Limit(1)
+
+	var profiles []*Profile
+	if _, err := query.GetAll(ctx, &profiles); err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return profiles[0], nil
+}
+
+// updateProfile saves the current user's notification and time zone settings.
+func updateProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	profile, err := LoadProfile(ctx, user.Current(ctx).Email)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	profile.MuteBroken = len(r.FormValue("notify_broken")) == 0
+	profile.MuteBuildFail = len(r.FormValue("notify_buildfail")) == 0
+	switch digest := r.FormValue("digest"); digest {
+	case digestDaily, digestWeekly, digestNever:
+		profile.Digest = digest
+	default:
+		http.Error(w, "Unknown digest frequency: "+digest, http.StatusBadRequest)
+		return
+	}
+	if zone := r.FormValue("timezone"); validTimeZone(zone) {
+		profile.TimeZone = zone
+	} else {
+		http.Error(w, "Unknown time zone: "+zone, http.StatusBadRequest)
+		return
+	}
+
+	if err := profile.Commit(ctx); err != nil {
+		http.Error(w, "Error comitting: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/widget/list", http.StatusFound)
+}
@@ -0,0 +1,172 @@
+package widget
+
+import (
+	"http"
+	"os"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+)
+
+type projectData struct {
+	CSS      string
+	Header   string
+	T        map[string]string
+	Widget   *Widget
+	Builds   []*Countable
+	Commits  []*Countable
+	Activity []*activityWeek
+	Broken   []*BrokenReport
+	Reviews  []*Review
+	Stars    *reviewSummary
+
+	// The logged in user, and their own review if they can write one
+	Viewer   string
+	MyReview *Review
+
+	// Only shown to the owner
+	Owner      bool
+	Deliveries []*Delivery
+}
+
+// An activityWeek is one bar of the activity chart on the project page.
+type activityWeek struct {
+	Label        string
+	Builds       int
+	Commits      int
+	BuildHeight  int
+	CommitHeight int
+}
+
+const (
+	activityWeeks  = 12
+	activityHeight = 60 // pixels
+	recentItems    = 10
+)
+
+// loadActivity returns the number of builds and commits in each of the last
+// activityWeeks weeks, oldest first.
+func loadActivity(ctx appengine.Context, widget *datastore.Key) (weeks []*activityWeek, err os.Error) {
+	const week = datastore.Time(7 * 24 * 60 * 60 * 1e6)
+	start := now() - activityWeeks*week
+
+	weeks = make([]*activityWeek, activityWeeks)
+	for i := range weeks {
+		weeks[i] = &activityWeek{
+			Label: time2date(start + datastore.Time(i)*week),
+		}
+	}
+
+	count := func(kind string, add func(w *activityWeek)) os.Error {
+		query := datastore.NewQuery(kind)
+		query.Filter("Widget =", widget)
+		query.Filter("Time >", start)
+		query.Order("-Time")
+		query.Limit(1000)
+
+		var items []*Countable
+		if _, err := query.GetAll(ctx, &items); err != nil {
+			return err
+		}
+		for _, c := range items {
+			if i := int((c.Time - start) / week); i >= 0 && i < len(weeks) {
+				add(weeks[i])
+			}
+		}
+		return nil
+	}
+	if err = count("Build", func(w *activityWeek) { w.Builds++ }); err != nil {
+		return nil, err
+	}
+	if err = count("Commit", func(w *activityWeek) { w.Commits++ }); err != nil {
+		return nil, err
+	}
+
+	max := 1
+	for _, w := range weeks {
+		if w.Builds > max {
+			max = w.Builds
+		}
+		if w.Commits > max {
+			max = w.Commits
+		}
+	}
+	for _, w := range weeks {
+		w.BuildHeight = w.Builds * activityHeight / max
+		w.CommitHeight = w.Commits * activityHeight / max
+	}
+	return weeks, nil
+}
+
+func showProject(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 2 {
+		http.Error(w, "/p/{widget} - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	widgethash := strings.ToUpper(path[1])
+	if len(widgethash) != 32 {
+		http.Error(w, "Invalid widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusNotFound)
+		return
+	}
+
+	loc := localeFor(r)
+	widget.locale = loc
+
+	data := projectData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+		T:      loc.Messages,
+		Widget: widget,
+	}
+
+	// The rest of the page is still useful without these
+	if data.Builds, err = LoadRecentCountable(ctx, "Build", widget.ID, recentItems); err != nil {
+		ctx.Warningf("Project %s: builds: %s", widget.ID, err)
+	}
+	if data.Commits, err = LoadRecentCountable(ctx, "Commit", widget.ID, recentItems); err != nil {
+		ctx.Warningf("Project %s: commits: %s", widget.ID, err)
+	}
+	if data.Activity, err = loadActivity(ctx, widget.key); err != nil {
+		ctx.Warningf("Project %s: activity: %s", widget.ID, err)
+	}
+	if err = markRated(ctx, r, []*Widget{widget}); err != nil {
+		ctx.Warningf("Project %s: rated: %s", widget.ID, err)
+	}
+	if data.Broken, err = LoadBrokenReports(ctx, widget.ID, recentItems); err != nil {
+		ctx.Warningf("Project %s: broken reports: %s", widget.ID, err)
+	}
+	if data.Reviews, err = LoadReviews(ctx, widget.key, recentReviews); err != nil {
+		ctx.Warningf("Project %s: reviews: %s", widget.ID, err)
+	}
+	if data.Stars, err = LoadReviewSummary(ctx, widget.key); err != nil {
+		ctx.Warningf("Project %s: review summary: %s", widget.ID, err)
+	}
+	if u := user.Current(ctx); u != nil && u.Email != widget.Owner {
+		data.Viewer = u.Email
+		data.MyReview = &Review{key: reviewKey(widget.key, u.Email), Widget: widget.key}
+		if err := datastore.Get(ctx, data.MyReview.key, data.MyReview); err != nil && err != datastore.ErrNoSuchEntity {
+			ctx.Warningf("Project %s: own review: %s", widget.ID, err)
+		}
+	}
+	if data.Owner = widget.CanEdit(); data.Owner {
+		if data.Deliveries, err = LoadDeliveries(ctx, widget.key, recentDeliveries); err != nil {
+			ctx.Warningf("Project %s: deliveries: %s", widget.ID, err)
+		}
+	}
+
+	if err := renderPage(w, loc, "project.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
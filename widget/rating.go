@@ -0,0 +1,68 @@
+package widget
+
+import (
+	"http"
+	"os"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+)
+
+// Ratings are Rating countables keyed by who gave them, so that each person
+// can rate a widget once and take their rating back.  Logged in users are
+// identified by their account; anyone else by their address, as all ratings
+// were before accounts were used.  Ratings made from an address are
+// never taken to be a logged in user's, as the address may be shared.
+
+// voterHash returns the hash identifying the person making a request.
+func voterHash(ctx appengine.Context, r *http.Request) string {
+	if u := user.Current(ctx); u != nil {
+		return Hashf("User=%s", strings.ToLower(u.Email))
+	}
+	return addressHash(r)
+}
+
+// addressHash returns the hash of the address a request came from, which
+// ratings were keyed by before accounts were used.
+func addressHash(r *http.Request) string {
+	ip := r.RemoteAddr
+	if ip == "" {
+		ip = "devel"
+	}
+	return Hashf("IP=%s|Unique=%d", ip, 0)
+}
+
+// markRated records on each widget whether the person making the request has
+// rated it, for Rated.
+func markRated(ctx appengine.Context, r *http.Request, widgets []*Widget) os.Error {
+	if len(widgets) == 0 {
+		return nil
+	}
+
+	query := datastore.NewQuery("Rating")
+	query.Filter("Hash =", voterHash(ctx, r))
+	query.KeysOnly()
+	query.Limit(1000)
+
+	keys, err := query.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	rated := make(map[string]bool)
+	for _, key := range keys {
+		rated[key.Parent().StringID()] = true
+	}
+	for _, w := range widgets {
+		w.rated = rated[w.ID]
+	}
+	return nil
+}
+
+// Rated returns true if the person viewing the widget has rated it.  It is
+// only set for widgets passed to markRated.
+func (w *Widget) Rated() bool {
+	return w.rated
+}
@@ -0,0 +1,167 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// A Fetcher retrieves the contents of a URL.
+type Fetcher interface {
+	Fetch(url string) ([]byte, os.Error)
+}
+
+// An HTTPFetcher is a Fetcher which uses an http.Client.  Outside of App
+// Engine it can be given an ordinary client, e.g. to fetch from a local server.
+type HTTPFetcher struct {
+	Client  *http.Client
+	MaxSize int64
+}
+
+// ErrNotFound is returned by an HTTPFetcher when the server has no such page.
+var ErrNotFound = os.NewError("not found")
+
+// ErrTooLarge is returned by an HTTPFetcher when the page is over MaxSize.
+var ErrTooLarge = os.NewError("too large")
+
+func (f *HTTPFetcher) Fetch(url string) ([]byte, os.Error) {
+	resp, err := f.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > f.MaxSize {
+		return nil, ErrTooLarge
+	}
+	return body, nil
+}
+
+// maxDescription is the longest description which is stored.
+const maxDescription = 64 * 1024
+
+// newFetcher returns the Fetcher used by the app.
+var newFetcher = func(ctx appengine.Context) Fetcher {
+	return &HTTPFetcher{
+		Client:  urlfetch.Client(ctx),
+		MaxSize: maxDescription,
+	}
+}
+
+var readmeNames = []string{"README.md", "README.markdown", "README", "README.txt"}
+
+// readmeURLs returns the places a README might be found for the given source
+// URL, in the order they should be tried.  Hosts which serve raw files from
+// a different address are special cased; for anything else, the README is
+// looked for directly under the source URL.
+func readmeURLs(source string) (urls []string) {
+	url, err := http.ParseURL(source)
+	if err != nil {
+		return nil
+	}
+	path := strings.Trim(url.Path, "/")
+	parts := strings.Split(path, "/", -1)
+
+	var base string
+	switch {
+	case url.Host == "github.com" && len(parts) >= 2:
+		base = "https://raw.github.com/" + parts[0] + "/" + parts[1] + "/master/"
+	case url.Host == "bitbucket.org" && len(parts) >= 2:
+		base = "https://bitbucket.org/" + parts[0] + "/" + parts[1] + "/raw/tip/"
+	case url.Host == "code.google.com" && len(parts) >= 2 && parts[0] == "p":
+		base = "http://" + parts[1] + ".googlecode.com/hg/"
+	default:
+		base = strings.TrimRight(source, "/") + "/"
+	}
+
+	for _, name := range readmeNames {
+		urls = append(urls, base+name)
+	}
+	return
+}
+
+// ImportReadme fetches the README for the given source URL.  It returns the
+// README and the URL it was found at.
+func ImportReadme(f Fetcher, source string) (readme []byte, from string, err os.Error) {
+	urls := readmeURLs(source)
+	if len(urls) == 0 {
+		return nil, "", fmt.Errorf("invalid source URL %q", source)
+	}
+
+	for _, url := range urls {
+		readme, err = f.Fetch(url)
+		switch {
+		case err == ErrNotFound:
+			continue
+		case err != nil:
+			return nil, "", err
+		}
+		return readme, url, nil
+	}
+	return nil, "", fmt.Errorf("no README found for %s", source)
+}
+
+func importReadme(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 3 {
+		http.Error(w, "/widget/readme/{widget} - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	widgethash := path[2]
+	if len(widgethash) != 32 {
+		http.Error(w, "Invalid widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+	if !widget.CanEdit() {
+		http.Error(w, "Only the owner can change this widget", http.StatusForbidden)
+		return
+	}
+	if len(widget.SourceURL) == 0 {
+		http.Error(w, "Set a Source URL to import the README from", http.StatusBadRequest)
+		return
+	}
+
+	readme, from, err := ImportReadme(newFetcher(ctx), widget.SourceURL)
+	if err != nil {
+		http.Error(w, "Error importing README: "+err.String(), http.StatusBadGateway)
+		return
+	}
+	ctx.Infof("Widget %s: imported README from %s", widget.ID, from)
+
+	widget.Description = readme
+	if len(widget.Summary) == 0 {
+		widget.Summary = cleanSummary(firstParagraph(string(readme)))
+	}
+
+	if err := widget.Commit(); err != nil {
+		http.Error(w, "Error comitting: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/widget/list", http.StatusFound)
+}
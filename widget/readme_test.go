@@ -0,0 +1,105 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testReadme = "# Widget\n\nA widget for testing.\n"
+
+func newReadmeServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/found/README.md":
+			fmt.Fprint(w, testReadme)
+		case "/plain/README":
+			fmt.Fprint(w, testReadme)
+		case "/large/README.md":
+			fmt.Fprint(w, strings.Repeat("x", 1024))
+		case "/broken/README.md":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	server := newReadmeServer()
+	defer server.Close()
+
+	f := &HTTPFetcher{
+		Client:  new(http.Client),
+		MaxSize: 512,
+	}
+
+	tests := []struct {
+		Path string
+		Body string
+		Err  os.Error
+	}{
+		{"/found/README.md", testReadme, nil},
+		{"/missing/README.md", "", ErrNotFound},
+		{"/large/README.md", "", ErrTooLarge},
+	}
+
+	for _, test := range tests {
+		body, err := f.Fetch(server.URL + test.Path)
+		if err != test.Err {
+			t.Errorf("Fetch(%q): error = %v, want %v", test.Path, err, test.Err)
+			continue
+		}
+		if got := string(body); got != test.Body {
+			t.Errorf("Fetch(%q) = %q, want %q", test.Path, got, test.Body)
+		}
+	}
+
+	if _, err := f.Fetch(server.URL + "/broken/README.md"); err == nil || err == ErrNotFound {
+		t.Errorf("Fetch(broken): error = %v, want the server's status", err)
+	}
+}
+
+func TestImportReadme(t *testing.T) {
+	server := newReadmeServer()
+	defer server.Close()
+
+	f := &HTTPFetcher{
+		Client:  new(http.Client),
+		MaxSize: maxDescription,
+	}
+
+	tests := []struct {
+		Source string
+		From   string
+		Fail   bool
+	}{
+		{"/found", "/found/README.md", false},
+		{"/plain/", "/plain/README", false},
+		{"/missing", "", true},
+		{"/broken", "", true},
+	}
+
+	for _, test := range tests {
+		readme, from, err := ImportReadme(f, server.URL+test.Source)
+		if test.Fail {
+			if err == nil {
+				t.Errorf("ImportReadme(%q) found %q, want error", test.Source, from)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ImportReadme(%q): %s", test.Source, err)
+			continue
+		}
+		if want := server.URL + test.From; from != want {
+			t.Errorf("ImportReadme(%q) from %q, want %q", test.Source, from, want)
+		}
+		if string(readme) != testReadme {
+			t.Errorf("ImportReadme(%q) = %q, want %q", test.Source, readme, testReadme)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"strconv"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+)
+
+// Reviews are written by logged in users, one per user per widget, with an
+// optional star rating.  They are separate from the +1 Rating countables,
+// which still make up CachedRating and the score.  Owners can reply to
+// reviews, anyone logged in can flag one for an admin to look at, and admins
+// can hide or delete them from /admin/reviews.
+
+// A Review is stored as a child of its widget, keyed by its author.
+type Review struct {
+	key *datastore.Key
+
+	Widget *datastore.Key
+	Author string
+	Stars  int64 // 1 to 5, or 0 for none
+	Text   string
+	Time   datastore.Time
+
+	Reply     string
+	ReplyTime datastore.Time
+
+	Flagged bool
+	Hidden  bool
+}
+
+const (
+	maxReview     = 1000
+	recentReviews = 20
+)
+
+func reviewKey(widget *datastore.Key, email string) *datastore.Key {
+	return datastore.NewKey("Review", Hashf("User=%s", strings.ToLower(email)), 0, widget)
+}
+
+func (rv *Review) Commit(ctx appengine.Context) (err os.Error) {
+	rv.key, err = datastore.Put(ctx, rv.key, rv)
+	return
+}
+
+func (rv *Review) KeyID() string {
+	return rv.key.Encode()
+}
+
+func (rv *Review) WidgetID() string {
+	return rv.Widget.StringID()
+}
+
+func (rv *Review) Date() string {
+	return timestr(rv.Time)
+}
+
+// AuthorName returns the name shown for the author, which leaves out the
+// domain of their address.
+func (rv *Review) AuthorName() string {
+	if at := strings.Index(rv.Author, "@"); at > 0 {
+		return rv.Author[:at]
+	}
+	return rv.Author
+}
+
+// StarString shows the rating as stars.
+func (rv *Review) StarString() string {
+	if rv.Stars == 0 {
+		return ""
+	}
+	return strings.Repeat("&#9733;", int(rv.Stars)) + strings.Repeat("&#9734;", 5-int(rv.Stars))
+}
+
+// StarOptions returns the ratings to choose from, for a select.
+func (rv *Review) StarOptions() (opts []*categoryOption) {
+	for stars := int64(5); stars >= 1; stars-- {
+		opts = append(opts, &categoryOption{strconv.Itoa64(stars), stars == rv.Stars})
+	}
+	return
+}
+
+// LoadReviews returns the most recent reviews of a widget which haven't
+// been hidden, newest first.
+func LoadReviews(ctx appengine.Context, widget *datastore.Key, limit int) (reviews []*Review, err os.Error) {
+	query := datastore.NewQuery("Review")
+	query.Ancestor(widget)
+	query.Filter("Hidden =", false)
+	query.Order("-Time")
+	query.Limit(limit)
+
+	var k []*datastore.Key
+	k, err = query.GetAll(ctx, &reviews)
+	for i, rv := range reviews {
+		rv.key = k[i]
+	}
+	return
+}
+
+// A reviewSummary is the average and distribution of a widget's stars.
+type reviewSummary struct {
+	Count   int
+	Average string
+	Dist    []*starCount
+}
+
+type starCount struct {
+	Stars int
+	Count int
+	Width int // pixels
+}
+
+const distWidth = 100 // pixels
+
+// LoadReviewSummary summarizes the stars given in a widget's visible reviews.
+func LoadReviewSummary(ctx appengine.Context, widget *datastore.Key) (*reviewSummary, os.Error) {
+	query := datastore.NewQuery("Review")
+	query.Ancestor(widget)
+	query.Filter("Hidden =", false)
+	query.Limit(1000)
+
+	var reviews []*Review
+	if _, err := query.GetAll(ctx, &reviews); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, 6)
+	var total int64
+	summary := new(reviewSummary)
+	for _, rv := range reviews {
+		if rv.Stars < 1 || rv.Stars > 5 {
+			continue
+		}
+		counts[rv.Stars]++
+		total += rv.Stars
+		summary.Count++
+	}
+	if summary.Count == 0 {
+		return summary, nil
+	}
+
+	summary.Average = fmt.Sprintf("%.1f", float64(total)/float64(summary.Count))
+	for stars := 5; stars >= 1; stars-- {
+		summary.Dist = append(summary.Dist, &starCount{
+			Stars: stars,
+			Count: counts[stars],
+			Width: counts[stars] * distWidth / summary.Count,
+		})
+	}
+	return summary, nil
+}
+
+// editReview handles the forms on the project page which change reviews:
+// writing or deleting your own, replying as the owner, and flagging one for
+// moderation.
+func editReview(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	u := user.Current(ctx)
+	if u == nil {
+		http.Error(w, "Log in to review projects", http.StatusForbidden)
+		return
+	}
+
+	var widget *Widget
+	var review *Review
+	var own bool
+	if id := r.FormValue("widget"); len(id) > 0 {
+		// Your own review
+		own = true
+		var err os.Error
+		if widget, err = LoadWidget(ctx, strings.ToUpper(id)); err != nil {
+			http.Error(w, "Unknown widget id: "+id, http.StatusBadRequest)
+			return
+		}
+		review = &Review{key: reviewKey(widget.key, u.Email)}
+	} else {
+		// Someone else's review
+		key, err := datastore.DecodeKey(r.FormValue("review"))
+		if err != nil || key.Kind() != "Review" || key.Parent() == nil {
+			http.Error(w, "Invalid review", http.StatusBadRequest)
+			return
+		}
+		if widget, err = LoadWidget(ctx, key.Parent().StringID()); err != nil {
+			http.Error(w, "Unknown widget id: "+key.Parent().StringID(), http.StatusBadRequest)
+			return
+		}
+		review = &Review{key: key}
+	}
+
+	err := datastore.Get(ctx, review.key, review)
+	exists := err == nil
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	// Reviews can only be written or deleted by their authors
+	action := r.FormValue("action")
+	if (action == "save" || action == "delete") && !own {
+		http.Error(w, "You can only change your own review", http.StatusForbidden)
+		return
+	}
+
+	switch action {
+	case "save":
+		stars, _ := strconv.Atoi64(r.FormValue("stars"))
+		if stars < 0 || stars > 5 {
+			http.Error(w, "Ratings are from 1 to 5 stars", http.StatusBadRequest)
+			return
+		}
+		text := truncate(strings.TrimSpace(r.FormValue("text")), maxReview)
+		if stars == 0 && len(text) == 0 {
+			http.Error(w, "Give a rating or write a review", http.StatusBadRequest)
+			return
+		}
+		if widget.Owner == u.Email {
+			http.Error(w, "You can't review your own project", http.StatusForbidden)
+			return
+		}
+		review.Widget = widget.key
+		review.Author = u.Email
+		review.Stars = stars
+		review.Text = text
+		review.Time = now()
+		err = review.Commit(ctx)
+	case "delete":
+		if exists {
+			err = datastore.Delete(ctx, review.key)
+		}
+	case "reply":
+		if !exists {
+			http.Error(w, "Unknown review", http.StatusBadRequest)
+			return
+		}
+		if !widget.CanEdit() {
+			http.Error(w, "Only the owner can reply to reviews", http.StatusForbidden)
+			return
+		}
+		review.Reply = truncate(strings.TrimSpace(r.FormValue("reply")), maxReview)
+		review.ReplyTime = now()
+		err = review.Commit(ctx)
+	case "flag":
+		if !exists {
+			http.Error(w, "Unknown review", http.StatusBadRequest)
+			return
+		}
+		review.Flagged = true
+		err = review.Commit(ctx)
+	default:
+		http.Error(w, "Unknown action: "+action, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error comitting: "+err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/p/"+widget.ID+"#reviews", http.StatusFound)
+}
+
+type reviewsData struct {
+	CSS    string
+	Header string
+	T      map[string]string
+	Review []*Review
+}
+
+// adminReviews lists flagged and recent reviews for moderation.  Hiding a
+// review takes it off the project page and out of the star rating; showing it
+// puts it back and clears the flag, and dismissing just clears the flag.
+func adminReviews(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	if r.Method == "POST" {
+		key, err := datastore.DecodeKey(r.FormValue("review"))
+		if err != nil || key.Kind() != "Review" {
+			http.Error(w, "Invalid review", http.StatusBadRequest)
+			return
+		}
+		review := &Review{key: key}
+		if err := datastore.Get(ctx, key, review); err != nil {
+			http.Error(w, err.String(), http.StatusBadRequest)
+			return
+		}
+
+		switch action := r.FormValue("action"); action {
+		case "hide":
+			review.Hidden, review.Flagged = true, false
+			err = review.Commit(ctx)
+		case "show":
+			review.Hidden, review.Flagged = false, false
+			err = review.Commit(ctx)
+		case "dismiss":
+			review.Flagged = false
+			err = review.Commit(ctx)
+		case "delete":
+			err = datastore.Delete(ctx, key)
+		default:
+			http.Error(w, "Unknown action: "+action, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+		ctx.Infof("Reviews: %s %s", r.FormValue("action"), key)
+		http.Redirect(w, r, "/admin/reviews", http.StatusFound)
+		return
+	}
+
+	loc := localeFor(r)
+
+	data := reviewsData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+		T:      loc.Messages,
+	}
+
+	load := func(query *datastore.Query) ([]*Review, os.Error) {
+		var reviews []*Review
+		keys, err := query.GetAll(ctx, &reviews)
+		for i, rv := range reviews {
+			rv.key = keys[i]
+		}
+		return reviews, err
+	}
+
+	query := datastore.NewQuery("Review")
+	query.Filter("Flagged =", true)
+	query.Limit(100)
+	flagged, err := load(query)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	query = datastore.NewQuery("Review")
+	query.Order("-Time")
+	query.Limit(50)
+	recent, err := load(query)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	data.Review = flagged
+	for _, rv := range recent {
+		if !rv.Flagged {
+			data.Review = append(data.Review, rv)
+		}
+	}
+
+	if err := renderPage(w, loc, "reviews.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,185 @@
+package widget
+
+import (
+	"http"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Widgets are indexed for search by storing the tokens found in their
+// searchable fields in Widget.SearchTokens.  A search for several terms is an
+// equality filter on SearchTokens for each of them, which the datastore can
+// answer without a composite index.
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "for": true, "go": true,
+	"in": true, "of": true, "or": true, "the": true, "to": true,
+	"http": true, "https": true, "www": true, "com": true, "org": true,
+}
+
+// tokenize splits text into lower case search tokens.
+func tokenize(text string) (tokens []string) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(c int) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	for _, word := range words {
+		if len(word) < 2 || len(word) > 32 || stopWords[word] {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return
+}
+
+// A searchField is a piece of a widget which is searched, how much a match in
+// it counts toward relevance, and how many new tokens it can add to the index
+// (0 for no limit).
+type searchField struct {
+	Text   string
+	Weight int
+	Max    int
+}
+
+// Every token is an index entry, and an entity can only have so many, so only
+// the start of the description is indexed and the total is capped.
+const (
+	searchDescriptionTokens = 100
+	searchTokensMax         = 200
+)
+
+func (w *Widget) searchFields() []searchField {
+	return []searchField{
+		{w.Name, 5, 0},
+		{strings.Join(w.Tags, " "), 3, 0},
+		{w.Category, 2, 0},
+		{w.Summary, 2, 0},
+		{w.HomeURL + " " + w.SourceURL + " " + w.BugURL, 1, 0},
+		{string(w.Description), 1, searchDescriptionTokens},
+	}
+}
+
+// searchTokens returns the tokens to index the widget under.
+func (w *Widget) searchTokens() (tokens []string) {
+	seen := make(map[string]bool)
+	for _, field := range w.searchFields() {
+		added := 0
+		for _, token := range tokenize(field.Text) {
+			if len(tokens) >= searchTokensMax {
+				return
+			}
+			if field.Max > 0 && added >= field.Max {
+				break
+			}
+			if !seen[token] {
+				seen[token] = true
+				tokens = append(tokens, token)
+				added++
+			}
+		}
+	}
+	return
+}
+
+// relevance returns how well the widget matches the search terms.
+func (w *Widget) relevance(terms []string) (score int) {
+	for _, field := range w.searchFields() {
+		tokens := make(map[string]bool)
+		for _, token := range tokenize(field.Text) {
+			tokens[token] = true
+		}
+		for _, term := range terms {
+			if tokens[term] {
+				score += field.Weight
+			}
+		}
+	}
+	return
+}
+
+// A searchResult is a widget matching a search.
+type searchResult struct {
+	Widget *Widget
+	Rank   float64
+}
+
+type searchResults []*searchResult
+
+func (r searchResults) Len() int           { return len(r) }
+func (r searchResults) Less(i, j int) bool { return r[i].Rank > r[j].Rank }
+func (r searchResults) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+const (
+	searchCandidates = 200
+	searchResultsMax = 50
+)
+
+// SearchWidgets returns the widgets matching every term in the query, ranked
+// by how well they match and their score.
+func SearchWidgets(ctx appengine.Context, q string) (results []*searchResult, err os.Error) {
+	terms := tokenize(q)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	query := datastore.NewQuery("Widget")
+	for _, term := range terms {
+		query.Filter("SearchTokens =", term)
+	}
+	query.Limit(searchCandidates)
+
+	var widgets []*Widget
+	keys, err := query.GetAll(ctx, &widgets)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, w := range widgets {
+		w.ctx = ctx
+		w.key = keys[i]
+		results = append(results, &searchResult{
+			Widget: w,
+			Rank:   float64(w.relevance(terms)) * (1 + float64(w.CachedScore)/5),
+		})
+	}
+	sort.Sort(searchResults(results))
+
+	if len(results) > searchResultsMax {
+		results = results[:searchResultsMax]
+	}
+	return results, nil
+}
+
+type searchData struct {
+	CSS    string
+	Header string
+	T      map[string]string
+	Query  string
+	Result []*searchResult
+}
+
+func searchPage(w http.ResponseWriter, r *http.Request) {
+	var err os.Error
+	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
+
+	data := searchData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+		T:      loc.Messages,
+		Query:  strings.TrimSpace(r.FormValue("q")),
+	}
+
+	if data.Result, err = SearchWidgets(ctx, data.Query); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := renderPage(w, loc, "search.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
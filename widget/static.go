@@ -1,8 +1,6 @@
 package widget
 
 import (
-	"template"
-	"io"
 	"bytes"
 	"fmt"
 
@@ -19,254 +17,104 @@ type ColorScheme struct {
 }
 
 var gowidgetColors = ColorScheme{
-	Main: Pallete{"#2E733E", "#30663C", "#1E602D", "#8BDD9D", "#B3DDBC"},
+	Main: Pallete{"#286436", "#2F643B", "#1E602D", "#8BDD9D", "#B3DDBC"},
 	Good: Pallete{"#26585C", "#274E52", "#19494E", "#89D1D8", "#AFD4D8"},
-	Warn: Pallete{"#98683D", "#866140", "#805128", "#E6B991", "#E6CFBA"},
-	Bad:  Pallete{"#98463D", "#864640", "#803028", "#E69991", "#E6BEBA"},
-}
-
-var widgetStatic string
-var widgetStaticTemplate = `` +
-`<style type="text/css">
-.gowidget
-{
-	margin: 5px;
-	width: 300px;
-	border-collapse: collapse;
-	border-spacing: 0;
-}
-
-.gowidget th, .gowidget td
-{
-	width: 150px;
-	font-weight: normal;
-	font-size: 10pt;
-	margin: 0;
-	padding: 2px 8px;
-	text-align: center;
-}
-
-.gowidget tbody th, .gowidget tbody td
-{
-	color: ${Main.Text};
-	background: ${Main.Background};
-	border: 1px solid ${Main.Border};
-}
-
-.gowidget tbody th
-{
-	width: 70px;
-}
-
-.gowidget thead th, .gowidget thead td
-{
-	color: ${Good.Text};
-	background: ${Good.Background};
-	border: 1px solid ${Good.Border};
-	font-size: 12pt;
-}
-
-.gowidget tfoot th, .gowidget tfoot td
-{
-	color: ${Good.Text};
-	border-top: 1px solid ${Good.Border};
-}
-
-.gowidget a:link, .gowidget a:hover, .gowidget a:active, .gowidget a:visited
-{
-	color: ${Main.Text};
-	text-decoration: none;
-}
-
-.gowidget a:hover
-{
-	text-decoration: underline;
-}
-
-.gowidget thead a:link, .gowidget thead a:hover, .gowidget thead a:active, .gowidget thead a:visited
-{
-	font-weight: bold;
-	color: ${Good.Text};
-}
-
-.gowidget th
-{
-	font-weight: bold;
-}
-
-.gowidget tfoot td
-{
-	font-size: 8pt;
-}
-
-.gowidget .tiny
-{
-	width: 100px;
-	display: inline-block;
-	font-size: 8pt;
-	text-align: left;
-}
-
-.gowidget .tiny:first-child
-{
-	text-align: right;
-}
-
-
-.gowidget .tiny a
-{
-	padding: 0 4px;
-}
-
-.gowidget tbody tr th:first-child
-{
-	text-align: right;
-}
-</style>
-<script type="text/javascript">
-function expand(element, index) {
-	table = element.parentNode.parentNode.parentNode.parentNode;
-	table.tBodies[index].style.display = "table-row-group";
-	table.tBodies[1-index].style.display = "none";
-}
-</script>
-`
-
-func writeWidgetCSS(w io.Writer) {
-	if len(widgetStatic) == 0 {
-		buf := bytes.NewBuffer(nil)
-		static := template.New(nil)
-		static.SetDelims("${", "}")
-		err := static.Parse(widgetStaticTemplate)
-		if err == nil {
-			err = static.Execute(buf, gowidgetColors)
+	Warn: Pallete{"#775230", "#735337", "#805128", "#E6B991", "#E6CFBA"},
+	Bad:  Pallete{"#843D35", "#7D413C", "#803028", "#E69991", "#E6BEBA"},
+}
+
+// widgetTabsJS switches between the tabs of the embedded widget.  The tabs
+// follow the WAI-ARIA tabs pattern: the arrow keys, Home and End move between
+// them.  Rather than using inline handlers, which a Content-Security-Policy
+// can block, it listens for events on the document (or, for the custom
+// element, the shadow root), and only once however many widgets there are.
+//
+// It also formats the widget's times: gowidgetTimes shows the full time in
+// the viewer's time zone when hovering, and updates how long ago it was,
+// since the widget may have been cached for a while.
+var widgetTabsJS = `` +
+	`if (!window.gowidgetListen) {
+	window.gowidgetTab = function(tab) {
+		var root = tab.getRootNode ? tab.getRootNode() : document;
+		var tabs = tab.parentNode.getElementsByTagName("button");
+		for (var i = 0; i < tabs.length; i++) {
+			var selected = tabs[i] == tab;
+			tabs[i].setAttribute("aria-selected", selected ? "true" : "false");
+			tabs[i].tabIndex = selected ? 0 : -1;
+			root.getElementById(tabs[i].getAttribute("aria-controls")).hidden = !selected;
 		}
-		if err != nil {
-			fmt.Fprintf(buf, "<b>Error</b>: %s<br/>", err)
+	};
+	window.gowidgetKey = function(event, tab) {
+		var tabs = tab.parentNode.getElementsByTagName("button");
+		var current = 0, next;
+		for (var i = 0; i < tabs.length; i++) {
+			if (tabs[i] == tab) current = i;
 		}
-		widgetStatic = buf.String()
-	}
-	fmt.Fprint(w, widgetStatic)
-}
-
-var commonStatic string
-var commonStaticTemplate = `` +
-`<style type="text/css">
-body
-{
-	margin-top: 30px;
-}
-
-#header
-{
-	position: absolute;
-	top: 0px;
-	right: 0px;
-	left: 0px;
-	margin: 0px;
-	padding: 0px;
-	text-align: right;
-	color: ${Main.Text};
-	background-color: ${Main.Background};
-	border-bottom: 1px solid ${Main.Border};
-}
-
-#header #userinfo
-{
-	padding: 5px 15px;
-	font-size: 15px;
-}
-
-#header a:link, #header a:hover, #header a:active, #header a:visited
-{
-	color: ${Main.Text};
-	text-decoration: none;
-}
-
-#header a:hover
-{
-	text-decoration: underline;
-}
-
-.leaderBoard
-{
-  counter-reset: widget;
-}
-
-.leaderBoard
-{
-	margin: 5px;
-	border-collapse: collapse;
-	border-spacing: 0;
-}
-
-.leaderBoard th, .leaderboard td
-{
-	font-weight: normal;
-	font-size: 12pt;
-	margin: 0;
-	padding: 2px 8px;
-	text-align: center;
-}
-
-.leaderBoard tbody th, .leaderBoard tbody td
-{
-	color: ${Main.Text};
-	background: ${Main.Background};
-	border: 1px solid ${Main.Border};
-}
-
-.leaderBoard thead th
-{
-	color: ${Good.Text};
-	background: ${Good.Background};
-	border: 1px solid ${Good.Border};
-	font-size: 14pt;
-}
-
-.leaderBoard th
-{
-	font-weight: bold;
-}
-
-.leaderBoard a:link, .leaderBoard a:hover, .leaderBoard a:active, .leaderBoard a:visited
-{
-	color: ${Main.Text};
-	text-decoration: none;
-}
-
-.leaderBoard a:hover
-{
-	text-decoration: underline;
-}
-
-.leaderBoard .topWidget:before
-{
-  counter-increment: widget;
-  content: "#" counter(widget);
+		switch (event.key || event.keyCode) {
+		case "ArrowLeft": case "Left": case 37: next = (current + tabs.length - 1) % tabs.length; break;
+		case "ArrowRight": case "Right": case 39: next = (current + 1) % tabs.length; break;
+		case "Home": case 36: next = 0; break;
+		case "End": case 35: next = tabs.length - 1; break;
+		default: return;
+		}
+		gowidgetTab(tabs[next]);
+		tabs[next].focus();
+		if (event.preventDefault) event.preventDefault();
+	};
+	window.gowidgetListen = function(root) {
+		var tabOf = function(event) {
+			var target = event.target || event.srcElement;
+			if (target && target.getAttribute && target.getAttribute("data-gowidget-tab")) return target;
+			return null;
+		};
+		root.addEventListener("click", function(event) {
+			var tab = tabOf(event);
+			if (tab) gowidgetTab(tab);
+		}, false);
+		root.addEventListener("keydown", function(event) {
+			var tab = tabOf(event);
+			if (tab) gowidgetKey(event, tab);
+		}, false);
+	};
+	window.gowidgetAgo = function(date, lang) {
+		var units = [["year", 31536000], ["month", 2592000], ["week", 604800], ["day", 86400], ["hour", 3600], ["minute", 60]];
+		var seconds = (new Date().getTime() - date.getTime()) / 1000;
+		for (var i = 0; i < units.length; i++) {
+			var n = Math.floor(seconds / units[i][1]);
+			if (n >= 1) return new Intl.RelativeTimeFormat(lang).format(-n, units[i][0]);
+		}
+		return null;
+	};
+	window.gowidgetTimes = function(root) {
+		var times = root.querySelectorAll("time[data-gowidget-time]");
+		for (var i = 0; i < times.length; i++) {
+			var date = new Date(times[i].getAttribute("datetime"));
+			if (isNaN(date.getTime())) continue;
+			var widget = times[i].closest ? times[i].closest("[lang]") : null;
+			var lang = widget ? widget.getAttribute("lang") : undefined;
+			times[i].title = date.toLocaleString(lang, {timeZoneName: "short"});
+			var ago = window.Intl && Intl.RelativeTimeFormat ? gowidgetAgo(date, lang) : null;
+			if (ago) times[i].textContent = ago;
+		}
+	};
+	gowidgetListen(document);
 }
+`
 
-.leaderBoard .right
-{
-	text-align: right;
-}
+// widgetScript is widgetTabsJS for the script and iframe embeds.  Like the
+// CSS, it must not contain any single quotes.
+var widgetScript = "<script type=\"text/javascript\">\n" + widgetTabsJS + "</script>\n"
 
-.leaderboard .left
-{
-	text-align: left;
-}
-</style>
-`
+// widgetTimesScript formats the times of the widgets above it.
+var widgetTimesScript = "<script type=\"text/javascript\">gowidgetTimes(document);</script>\n"
 
+var commonStatic string
+// commonCSS returns the CSS of every page, from common.css.  It is only
+// rendered once, except on the development server, where it may change.
 func commonCSS() string {
-	if len(commonStatic) == 0 {
+	if len(commonStatic) == 0 || devAppServer {
 		buf := bytes.NewBuffer(nil)
-		static := template.New(nil)
-		static.SetDelims("${", "}")
-		err := static.Parse(commonStaticTemplate)
-		if err == nil {
-			err = static.Execute(buf, gowidgetColors)
-		}
+		err := executeTemplate(buf, locales[0], "common.css", gowidgetColors)
 		if err != nil {
 			buf.Truncate(0)
 			fmt.Fprintf(buf, "<b>Error</b>: %s<br/>", err)
@@ -276,37 +124,36 @@ func commonCSS() string {
 	return commonStatic
 }
 
-var headerTemplate = ``+
-`<div id='header'>
-	<div id='userinfo'>
-{.section User}
-		{Email|html}
-		| <a href="/logout">Log Out</a> 
-		| <a href="/widget/list">My Projects</a>
-{.or}
-		<a href="/login">Log In</a>
-{.end}
-		| <a href="/leaderboard">Popular Projects</a>
-	</div>
-</div>
-`
-
 type headerData struct {
 	User *user.User
+	Admin bool
+	T map[string]string
+	Lang string
+	TimeZone string
+	Script string
 }
 
-func header(ctx appengine.Context) string {
-	page, err := template.Parse(headerTemplate, nil)
-	if err != nil {
-		return fmt.Sprintf("<b>Error</b>: %s<br/>", err)
-	}
-
+// header returns the header of every page, in the given locale.  It includes
+// the script which formats the times on the page in the user's time zone.
+func header(ctx appengine.Context, loc *Locale) string {
 	data := &headerData{
 		User: user.Current(ctx),
+		Admin: user.IsAdmin(ctx),
+		T: loc.Messages,
+		Lang: loc.Name,
+		Script: pageTimesScript,
+	}
+	if data.User != nil {
+		profile, err := LoadProfile(ctx, data.User.Email)
+		if err != nil {
+			ctx.Warningf("Header: profile: %s", err)
+		} else {
+			data.TimeZone = profile.TimeZone
+		}
 	}
 
 	buf := bytes.NewBuffer(nil)
-	err = page.Execute(buf, data)
+	err := executeTemplate(buf, loc, "header.html", data)
 	if err != nil {
 		return fmt.Sprintf("<b>Error</b>: %s<br/>", err)
 	}
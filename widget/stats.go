@@ -0,0 +1,214 @@
+package widget
+
+import (
+	"fmt"
+	"os"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+)
+
+// statsVersion must be bumped whenever the layout or meaning of Stats
+// changes.  It is part of the cache key, so entries written by an older
+// version of the app are simply never seen by a newer one.
+const statsVersion = 3
+
+// statsExpiration is how long computed stats stay cached.  Hooks invalidate
+// the cache when they write, but some values (e.g. builds this week) are
+// relative to the current time, so they can't be cached forever.
+const statsExpiration = 12 * 60 * 60
+
+// Stats holds the values computed from the countables of a single widget.
+type Stats struct {
+	Version int
+
+	Rating int
+	Broken int
+
+	Builds    int
+	BuildWeek int
+	BuildHead int
+	BuildLast datastore.Time
+
+	Commits    int
+	CommitWeek int
+	CommitLast datastore.Time
+
+	// Activity holds the builds and commits in each of the last
+	// activityWeeks weeks, oldest first, for the sparkline.
+	Activity []int
+}
+
+func statsKey(widgetid string) string {
+	return fmt.Sprintf("widget:v%d:%s", statsVersion, widgetid)
+}
+
+// lastStatsKey holds a copy of the stats which never expires, so that there is
+// something to show if the datastore is unavailable.
+func lastStatsKey(widgetid string) string {
+	return statsKey(widgetid) + ":last"
+}
+
+// computeStats computes the stats for a widget from its countables.
+func computeStats(ctx appengine.Context, widget *datastore.Key) (stats *Stats, err os.Error) {
+	stats = new(Stats)
+	lastweek := now() - datastore.SecondsToTime(7*24*60*60)
+
+	var query *datastore.Query
+	var items []*Countable
+
+	// Rating
+	query = datastore.NewQuery("Rating")
+	query.Filter("Widget =", widget)
+	if stats.Rating, err = query.Count(ctx); err != nil {
+		return nil, fmt.Errorf("count rating: %s", err)
+	}
+
+	// Get Commits
+	query = datastore.NewQuery("Commit")
+	query.Filter("Widget =", widget)
+	query.Order("-Time")
+	if stats.Commits, err = query.Count(ctx); err != nil {
+		return nil, fmt.Errorf("count commits: %s", err)
+	}
+	if rollup, err := LoadRollup(ctx, widget, "Commit"); err != nil {
+		return nil, fmt.Errorf("commit rollup: %s", err)
+	} else {
+		stats.Commits += rollup.Count
+	}
+	query.Filter("Time >", lastweek)
+	if stats.CommitWeek, err = query.Count(ctx); err != nil {
+		return nil, fmt.Errorf("count weekly commits: %s", err)
+	}
+
+	query = datastore.NewQuery("Commit")
+	query.Filter("Widget =", widget)
+	query.Order("-Time")
+	query.Limit(1)
+	if _, err = query.GetAll(ctx, &items); err != nil {
+		return nil, fmt.Errorf("last commit: %s", err)
+	}
+	if len(items) > 0 {
+		stats.CommitLast = items[0].Time
+	}
+
+	// Get builds
+	query = datastore.NewQuery("Build")
+	query.Filter("Widget =", widget)
+	query.Order("-Time")
+	if stats.Builds, err = query.Count(ctx); err != nil {
+		return nil, fmt.Errorf("count builds: %s", err)
+	}
+	if rollup, err := LoadRollup(ctx, widget, "Build"); err != nil {
+		return nil, fmt.Errorf("build rollup: %s", err)
+	} else {
+		stats.Builds += rollup.Count
+	}
+	query.Filter("Time >", lastweek)
+	if stats.BuildWeek, err = query.Count(ctx); err != nil {
+		return nil, fmt.Errorf("count weekly builds: %s", err)
+	}
+
+	items = nil
+	query = datastore.NewQuery("Build")
+	query.Filter("Widget =", widget)
+	query.Order("-Time")
+	query.Limit(1)
+	if _, err = query.GetAll(ctx, &items); err != nil {
+		return nil, fmt.Errorf("last build: %s", err)
+	}
+	if len(items) > 0 {
+		stats.BuildLast = items[0].Time
+	}
+	if stats.CommitLast > 0 {
+		query = datastore.NewQuery("Build")
+		query.Filter("Widget =", widget)
+		query.Filter("Time >", stats.CommitLast)
+		if stats.BuildHead, err = query.Count(ctx); err != nil {
+			return nil, fmt.Errorf("count builds at head: %s", err)
+		}
+	}
+
+	weeks, err := loadActivity(ctx, widget)
+	if err != nil {
+		return nil, fmt.Errorf("activity: %s", err)
+	}
+	for _, w := range weeks {
+		stats.Activity = append(stats.Activity, w.Builds+w.Commits)
+	}
+
+	// Broken reports only count until they are resolved or there is a new
+	// commit
+	if stats.Broken, err = countOpenBroken(ctx, widget, stats.CommitLast); err != nil {
+		return nil, fmt.Errorf("count broken: %s", err)
+	}
+
+	return stats, nil
+}
+
+// LoadStats returns the cached stats for the given widget, or nil if there
+// are none.  Entries that can't be decoded are discarded and treated as a
+// miss.
+func LoadStats(ctx appengine.Context, widgetid string) *Stats {
+	stats := new(Stats)
+	_, err := memcache.Gob.Get(ctx, statsKey(widgetid), stats)
+	switch {
+	case err == memcache.ErrCacheMiss:
+		countMetric(ctx, "stats.miss")
+		return nil
+	case err == nil && stats.Version == statsVersion:
+		countMetric(ctx, "stats.hit")
+		return stats
+	case err == nil:
+		err = fmt.Errorf("version %d, want %d", stats.Version, statsVersion)
+	}
+
+	ctx.Warningf("Stats: discarding cached stats for %s: %s", widgetid, err)
+	countMetric(ctx, "stats.decode_error")
+	if err := memcache.Delete(ctx, statsKey(widgetid)); err != nil && err != memcache.ErrCacheMiss {
+		ctx.Debugf("Stats: delete %s: %s", widgetid, err)
+	}
+	return nil
+}
+
+// LoadLastStats returns the last stats successfully computed for the given
+// widget, regardless of how old they are, or nil if there are none.
+func LoadLastStats(ctx appengine.Context, widgetid string) *Stats {
+	stats := new(Stats)
+	if _, err := memcache.Gob.Get(ctx, lastStatsKey(widgetid), stats); err != nil {
+		return nil
+	}
+	if stats.Version != statsVersion {
+		return nil
+	}
+	return stats
+}
+
+// SaveStats caches the stats for the given widget.
+func SaveStats(ctx appengine.Context, widgetid string, stats *Stats) os.Error {
+	stats.Version = statsVersion
+	err := memcache.Gob.Set(ctx, &memcache.Item{
+		Key:    lastStatsKey(widgetid),
+		Object: stats,
+	})
+	if err != nil {
+		ctx.Debugf("Stats: save last %s: %s", widgetid, err)
+	}
+	return memcache.Gob.Set(ctx, &memcache.Item{
+		Key:        statsKey(widgetid),
+		Expiration: statsExpiration,
+		Object:     stats,
+	})
+}
+
+// InvalidateStats drops the cached stats for the given widget, so that they
+// are recomputed from the datastore the next time they are needed.
+func InvalidateStats(ctx appengine.Context, widgetid string) os.Error {
+	countMetric(ctx, "stats.invalidate")
+	err := memcache.Delete(ctx, statsKey(widgetid))
+	if err == memcache.ErrCacheMiss {
+		err = nil
+	}
+	return err
+}
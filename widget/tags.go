@@ -0,0 +1,352 @@
+package widget
+
+import (
+	"fmt"
+	"http"
+	"os"
+	"regexp"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/taskqueue"
+)
+
+// categories are the primary categories an owner can choose from.
+var categories = []string{
+	"library",
+	"application",
+	"tool",
+	"framework",
+	"binding",
+	"other",
+}
+
+func validCategory(category string) bool {
+	for _, c := range categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// A Tag is a tag which has been used on a widget.  Admins can make a tag a
+// synonym of another by setting Canonical, in which case the other tag is
+// used instead.
+type Tag struct {
+	Name      string
+	Canonical string
+
+	// Count is the number of widgets with the tag, as of the last recount.
+	Count int64
+}
+
+const maxTags = 10
+
+func tagKey(name string) *datastore.Key {
+	return datastore.NewKey("Tag", name, 0, nil)
+}
+
+var tagFixup = regexp.MustCompile(`[^a-z0-9+.\-]`)
+
+// normalizeTag returns the tag in its stored form, or an empty string if
+// nothing is left of it.
+func normalizeTag(tag string) string {
+	tag = tagFixup.ReplaceAllString(strings.ToLower(strings.TrimSpace(tag)), "")
+	if len(tag) > 32 {
+		tag = tag[:32]
+	}
+	return tag
+}
+
+func LoadTag(ctx appengine.Context, name string) (tag *Tag, err os.Error) {
+	tag = new(Tag)
+	err = datastore.Get(ctx, tagKey(name), tag)
+	return
+}
+
+func (t *Tag) Commit(ctx appengine.Context) (err os.Error) {
+	_, err = datastore.Put(ctx, tagKey(t.Name), t)
+	return
+}
+
+// ParseTags turns a comma or space separated list of tags into canonical tags.
+// Tags which haven't been seen before are recorded, so that admins can curate
+// them.
+func ParseTags(ctx appengine.Context, raw string) (tags []string, err os.Error) {
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(strings.Replace(raw, ",", " ", -1)) {
+		name := normalizeTag(field)
+		if len(name) == 0 {
+			continue
+		}
+
+		tag, err := LoadTag(ctx, name)
+		switch {
+		case err == datastore.ErrNoSuchEntity:
+			tag = &Tag{Name: name}
+			if err := tag.Commit(ctx); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		case len(tag.Canonical) > 0:
+			name = tag.Canonical
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			tags = append(tags, name)
+		}
+		if len(tags) == maxTags {
+			break
+		}
+	}
+	return tags, nil
+}
+
+// TagList returns the widget's tags in the form they are edited in.
+func (w *Widget) TagList() string {
+	return strings.Join(w.Tags, ", ")
+}
+
+type categoryOption struct {
+	Name     string
+	Selected bool
+}
+
+func (w *Widget) CategoryOptions() (opts []*categoryOption) {
+	for _, c := range categories {
+		opts = append(opts, &categoryOption{c, c == w.Category})
+	}
+	return
+}
+
+// A cloudTag is a tag in the tag cloud on the leader board.
+type cloudTag struct {
+	Name  string
+	Count int64
+	Size  int // 1 to 5
+}
+
+const cloudTags = 30
+
+func LoadTagCloud(ctx appengine.Context) (cloud []*cloudTag, err os.Error) {
+	query := datastore.NewQuery("Tag")
+	query.Filter("Canonical =", "")
+	query.Order("-Count")
+	query.Limit(cloudTags)
+
+	var tags []*Tag
+	if _, err = query.GetAll(ctx, &tags); err != nil {
+		return nil, err
+	}
+
+	for _, tag := range tags {
+		if tag.Count == 0 {
+			break
+		}
+		cloud = append(cloud, &cloudTag{
+			Name:  tag.Name,
+			Count: tag.Count,
+			Size:  int(1 + 4*tag.Count/tags[0].Count),
+		})
+	}
+	return cloud, nil
+}
+
+// cronTags recounts the number of widgets with each tag.
+func cronTags(ctx appengine.Context, cursor string) (next string, processed int, err os.Error) {
+	query := datastore.NewQuery("Tag")
+	if len(cursor) > 0 {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", 0, err
+		}
+		query.Start(c)
+	}
+	iter := query.Run(ctx)
+
+	for processed < 20 {
+		tag := new(Tag)
+		if _, err := iter.Next(tag); err == datastore.Done {
+			return "", processed, nil
+		} else if err != nil {
+			return "", processed, err
+		}
+
+		count := datastore.NewQuery("Widget")
+		count.Filter("Tags =", tag.Name)
+		n, err := count.Count(ctx)
+		if err != nil {
+			return "", processed, err
+		}
+		if tag.Count != int64(n) {
+			tag.Count = int64(n)
+			if err := tag.Commit(ctx); err != nil {
+				return "", processed, err
+			}
+		}
+		processed++
+	}
+
+	c, err := iter.Cursor()
+	if err != nil {
+		return "", processed, err
+	}
+	return c.String(), processed, nil
+}
+
+// taskMergeTag replaces a tag with its canonical tag on a batch of widgets,
+// and queues itself again until there are none left.
+func taskMergeTag(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if len(from) == 0 || len(to) == 0 {
+		http.Error(w, "/task/tags/merge?from={tag}&to={tag} - missing parameter", http.StatusBadRequest)
+		return
+	}
+	if from == to {
+		// Don't let the task queue retry this forever
+		ctx.Errorf("Merge tags: can't merge %q into itself", from)
+		return
+	}
+
+	query := datastore.NewQuery("Widget")
+	query.Filter("Tags =", from)
+	query.Limit(20)
+
+	var widgets []*Widget
+	keys, err := query.GetAll(ctx, &widgets)
+	if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	for i, widget := range widgets {
+		widget.ctx = ctx
+		widget.key = keys[i]
+
+		var tags []string
+		seen := map[string]bool{}
+		for _, tag := range widget.Tags {
+			if tag == from {
+				tag = to
+			}
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		widget.Tags = tags
+
+		if err := widget.Commit(); err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(widgets) > 0 {
+		task := taskqueue.NewPOSTTask("/task/tags/merge", http.Values{
+			"from": {from},
+			"to":   {to},
+		})
+		if _, err := taskqueue.Add(ctx, task, "default"); err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Merged %s into %s on %d widgets\n", from, to, len(widgets))
+}
+
+// MergeTag makes from a synonym of to, and queues the widgets tagged with it
+// to be retagged.
+func MergeTag(ctx appengine.Context, from, to string) os.Error {
+	if from == to {
+		return os.NewError("can't merge a tag into itself")
+	}
+
+	target, err := LoadTag(ctx, to)
+	if err != nil {
+		return fmt.Errorf("%s: %s", to, err)
+	}
+	if len(target.Canonical) > 0 {
+		to = target.Canonical
+	}
+	if from == to {
+		return fmt.Errorf("can't merge %s into its own synonym", from)
+	}
+
+	// Anything which was a synonym for from is now a synonym for to
+	query := datastore.NewQuery("Tag")
+	query.Filter("Canonical =", from)
+	var synonyms []*Tag
+	if _, err := query.GetAll(ctx, &synonyms); err != nil {
+		return err
+	}
+	synonyms = append(synonyms, &Tag{Name: from})
+	for _, tag := range synonyms {
+		tag.Canonical = to
+		tag.Count = 0
+		if err := tag.Commit(ctx); err != nil {
+			return err
+		}
+	}
+
+	task := taskqueue.NewPOSTTask("/task/tags/merge", http.Values{
+		"from": {from},
+		"to":   {to},
+	})
+	_, err = taskqueue.Add(ctx, task, "default")
+	return err
+}
+
+type tagsData struct {
+	CSS    string
+	Header string
+	T      map[string]string
+	Error  string
+	Tag    []*Tag
+}
+
+func adminTags(w http.ResponseWriter, r *http.Request) {
+	var err os.Error
+	ctx := appengine.NewContext(r)
+	loc := localeFor(r)
+
+	data := tagsData{
+		CSS:    commonCSS(),
+		Header: header(ctx, loc),
+		T:      loc.Messages,
+	}
+
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "add":
+			if name := normalizeTag(r.FormValue("tag")); len(name) > 0 {
+				err = (&Tag{Name: name}).Commit(ctx)
+			}
+		case "merge":
+			err = MergeTag(ctx, normalizeTag(r.FormValue("from")), normalizeTag(r.FormValue("to")))
+		}
+		if err == nil {
+			http.Redirect(w, r, "/admin/tags", http.StatusFound)
+			return
+		}
+		data.Error = err.String()
+	}
+
+	query := datastore.NewQuery("Tag")
+	query.Order("Name")
+	if _, err = query.GetAll(ctx, &data.Tag); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := renderPage(w, loc, "tags.html", data); err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+	}
+}
@@ -11,67 +11,152 @@ import (
 	"appengine/taskqueue"
 )
 
-func taskUpgrade(out http.ResponseWriter, r *http.Request) {
-	out.Header().Set("Content-Type", "text/plain")
-
-	var (
-		ctx  = appengine.NewContext(r)
-		done = make(chan os.Error)
-
-		testing = false
-
-		widgets []*Widget
-	)
-
-	// Load widgets
-	query := datastore.NewQuery("Widget")
-	iter := query.Run(ctx)
-
-	for {
-		widget := make(datastore.Map)
-		key, err := iter.Next(widget)
-		if err == datastore.Done {
-			break
-		} else if err != nil {
-			fmt.Fprintf(out, "Next(widget): %s\n", err)
-			continue
-		}
-		w := new(Widget)
-		w.ctx = ctx
-		w.key = key
-		w.Name, _ = widget["Name"].(string)
-		w.ID, _ = widget["ID"].(string)
-		w.Owner, _ = widget["Owner"].(string)
-		w.HomeURL, _ = widget["HomeURL"].(string)
-		w.BugURL, _ = widget["BugURL"].(string)
-		w.SourceURL, _ = widget["SourceURL"].(string)
-		w.CachedScore, _ = widget["CachedScore"].(int64)
-		w.CachedRating, _ = widget["CachedRating"].(int64)
-
-		widgets = append(widgets, w)
-		go func() {
-			var err os.Error
-			w.populate()
-			if !testing {
-				err = w.Commit()
+func init() {
+	registerMigration(&Migration{
+		Name:    "widget-stats",
+		Version: 1,
+		Kind:    "Widget",
+		Apply:   upgradeWidgetStats,
+	})
+	registerMigration(&Migration{
+		Name:    "widget-leaderboard-fields",
+		Version: 1,
+		Kind:    "Widget",
+		Apply:   upgradeWidgetLeaderBoard,
+	})
+	registerMigration(&Migration{
+		Name:    "widget-search-index",
+		Version: 1,
+		Kind:    "Widget",
+		Apply:   upgradeWidgetSearch,
+	})
+	registerMigration(&Migration{
+		Name:    "broken-report-status",
+		Version: 1,
+		Kind:    "Broken",
+		Apply:   upgradeBrokenReport,
+	})
+}
+
+// widgetFromMap builds a Widget from its raw properties, so that widgets
+// written by older versions of the app can still be loaded.
+func widgetFromMap(ctx appengine.Context, key *datastore.Key, props datastore.Map) *Widget {
+	w := new(Widget)
+	w.ctx = ctx
+	w.key = key
+	w.Name, _ = props["Name"].(string)
+	w.ID, _ = props["ID"].(string)
+	w.Owner, _ = props["Owner"].(string)
+	w.HomeURL, _ = props["HomeURL"].(string)
+	w.BugURL, _ = props["BugURL"].(string)
+	w.SourceURL, _ = props["SourceURL"].(string)
+	w.Summary, _ = props["Summary"].(string)
+	w.Description, _ = props["Description"].([]byte)
+	w.EmbedSummary, _ = props["EmbedSummary"].(bool)
+	w.Theme, _ = props["Theme"].(string)
+	w.ThemeColors, _ = props["ThemeColors"].(string)
+	w.Layout, _ = props["Layout"].(string)
+	w.Sections = stringsFromProp(props["Sections"])
+	w.CachedScore, _ = props["CachedScore"].(int64)
+	w.CachedRating, _ = props["CachedRating"].(int64)
+	w.CachedBuildWeek, _ = props["CachedBuildWeek"].(int64)
+	w.CachedCommitLast, _ = props["CachedCommitLast"].(datastore.Time)
+	w.ScoreTiers = int64sFromProp(props["ScoreTiers"])
+	w.Created, _ = props["Created"].(datastore.Time)
+	w.Category, _ = props["Category"].(string)
+	w.Tags = stringsFromProp(props["Tags"])
+	return w
+}
+
+// stringsFromProp returns the strings in a list property.  A property with a
+// single value is loaded as that value rather than as a list.
+func stringsFromProp(prop interface{}) (list []string) {
+	switch v := prop.(type) {
+	case string:
+		list = append(list, v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
 			}
-			fmt.Fprintf(out, "Widget: Upgraded %s\n", w.ID)
-			if testing {
-				fmt.Fprintf(out, "   IN  %#v\n", widget)
-				fmt.Fprintf(out, "   OUT %#v\n", w)
+		}
+	}
+	return
+}
+
+// int64sFromProp is stringsFromProp for integers.
+func int64sFromProp(prop interface{}) (list []int64) {
+	switch v := prop.(type) {
+	case int64:
+		list = append(list, v)
+	case []interface{}:
+		for _, item := range v {
+			if i, ok := item.(int64); ok {
+				list = append(list, i)
 			}
-			done <- err
-		}()
+		}
+	}
+	return
+}
+
+// upgradeWidgetStats rewrites a widget with freshly computed cached stats.
+func upgradeWidgetStats(ctx appengine.Context, key *datastore.Key, props datastore.Map, dryRun bool) (bool, os.Error) {
+	w := widgetFromMap(ctx, key, props)
+	w.dirty = true
+	if err := w.populate(); err != nil {
+		return false, err
+	}
+	if dryRun {
+		ctx.Infof("Widget %s: IN  %#v", w.ID, props)
+		ctx.Infof("Widget %s: OUT %#v", w.ID, w)
+		return true, nil
 	}
+	return true, w.Commit()
+}
 
-	for _ = range widgets {
-		err := <-done
-		if err != nil {
-			fmt.Fprintf(out, "Commit(widget): %s\n", err)
+// upgradeWidgetLeaderBoard fills in the fields used to sort and filter the
+// leader board.  Widgets created before Created existed are dated by their
+// first commit or build, if they have one.
+func upgradeWidgetLeaderBoard(ctx appengine.Context, key *datastore.Key, props datastore.Map, dryRun bool) (bool, os.Error) {
+	w := widgetFromMap(ctx, key, props)
+	if w.Created == 0 {
+		w.Created = now()
+		for _, kind := range []string{"Commit", "Build"} {
+			query := datastore.NewQuery(kind)
+			query.Filter("Widget =", key)
+			query.Order("Time")
+			query.Limit(1)
+
+			var first []*Countable
+			if _, err := query.GetAll(ctx, &first); err != nil {
+				return false, err
+			}
+			if len(first) > 0 && first[0].Time < w.Created {
+				w.Created = first[0].Time
+			}
 		}
 	}
 
-	fmt.Fprintf(out, "COMPLETE\n")
+	w.dirty = true
+	if err := w.populate(); err != nil {
+		return false, err
+	}
+	if dryRun {
+		ctx.Infof("Widget %s: created %s, tiers %v", w.ID, timestr(w.Created), w.ScoreTiers)
+		return true, nil
+	}
+	return true, w.Commit()
+}
+
+// upgradeWidgetSearch indexes a widget for search, which happens whenever it
+// is committed.
+func upgradeWidgetSearch(ctx appengine.Context, key *datastore.Key, props datastore.Map, dryRun bool) (bool, os.Error) {
+	w := widgetFromMap(ctx, key, props)
+	if dryRun {
+		ctx.Infof("Widget %s: tokens %v", w.ID, w.searchTokens())
+		return true, nil
+	}
+	return true, w.Commit()
 }
 
 func taskRefresh(w http.ResponseWriter, r *http.Request) {
@@ -96,8 +181,14 @@ func taskRefresh(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unknown widget id: " + widgetID, http.StatusBadRequest)
 		return
 	}
+	score := widget.CachedScore
 	widget.dirty = true
-	widget.populate()
+	if err = widget.populate(); err != nil {
+		// Let the task queue retry it later
+		http.Error(w, "Populate: " + err.String(), http.StatusInternalServerError)
+		return
+	}
+	fireScoreChanged(ctx, widget, score)
 	err = widget.Commit()
 	if err != nil {
 		ctx.Debugf("update: commit: %s", err)
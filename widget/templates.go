@@ -0,0 +1,206 @@
+package widget
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"template"
+)
+
+// Pages, the embedded widget and their CSS are rendered from the files in the
+// templates directory, which is deployed with the app.  A file of the same
+// name in the theme directory takes its place, so that a deployment can change
+// how the site looks without changing the code.  Files ending in .css use ${
+// and } as delimiters, so that they don't clash with the braces of CSS.
+//
+// Templates are parsed for a locale the first time they are used, and an
+// error in one is returned by the handler using it.  On the development
+// server, templates are reloaded whenever their files change.
+
+// templateDir and themeDir are relative to the working directory, which is
+// the app's directory when it is served.  Tests run in the package directory
+// and change them to point at the app's.
+var (
+	templateDir = "templates"
+	themeDir    = "theme"
+)
+
+// layoutTemplate is what every page is rendered inside of.
+const layoutTemplate = "layout.html"
+
+// Pages are made of blocks, which are rendered into the {Title}, {Head} and
+// {Body} of the layout.  Each block starts with a line like "{.block body}",
+// and runs until the next one.  A template without blocks is a single block,
+// named "".
+const blockPrefix = "{.block "
+
+// devAppServer is true when running on the development server.
+var devAppServer = strings.HasPrefix(os.Getenv("SERVER_SOFTWARE"), "Development")
+
+// A namedTemplate is a template file, parsed for a locale.
+type namedTemplate struct {
+	Path   string // the file it was loaded from
+	Mtime  int64  // when the file was modified, in nanoseconds
+	Blocks map[string]*template.Template
+}
+
+// templates holds the parsed templates, keyed by locale and name, e.g.
+// "de:widget/full.html".
+var templates = make(map[string]*namedTemplate)
+
+// templateNames returns the names of the templates in a directory of the
+// templates directory, and in its subdirectories.
+func templateNames(dir string) (names []string, err os.Error) {
+	files, err := ioutil.ReadDir(path.Join(templateDir, dir))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := path.Join(dir, f.Name)
+		switch {
+		case strings.HasPrefix(f.Name, "."):
+		case f.IsDirectory():
+			sub, err := templateNames(name)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, sub...)
+		case f.IsRegular():
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// templateFile returns the file a template is loaded from, preferring the
+// theme directory, and when it was modified.
+func templateFile(name string) (file string, mtime int64, err os.Error) {
+	for _, dir := range []string{themeDir, templateDir} {
+		file = path.Join(dir, name)
+		fi, err := os.Stat(file)
+		if err == nil {
+			return file, fi.Mtime_ns, nil
+		}
+	}
+	return "", 0, os.NewError("no such template: " + name)
+}
+
+// splitBlocks splits the text of a template into its blocks.
+func splitBlocks(text string) map[string]string {
+	blocks := make(map[string]string)
+	name, block := "", ""
+	for _, line := range strings.SplitAfter(text, "\n", -1) {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, blockPrefix) || !strings.HasSuffix(trimmed, "}") {
+			block += line
+			continue
+		}
+		// Only whitespace can come before the first block
+		if len(name) > 0 || len(strings.TrimSpace(block)) > 0 {
+			blocks[name] = block
+		}
+		name, block = strings.TrimSpace(trimmed[len(blockPrefix):len(trimmed)-1]), ""
+	}
+	blocks[name] = block
+	return blocks
+}
+
+// loadTemplate reads and parses a template for a locale.
+func loadTemplate(loc *Locale, name string) (*namedTemplate, os.Error) {
+	file, mtime, err := templateFile(name)
+	if err != nil {
+		return nil, err
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &namedTemplate{
+		Path:   file,
+		Mtime:  mtime,
+		Blocks: make(map[string]*template.Template),
+	}
+	for block, body := range splitBlocks(string(text)) {
+		parsed := template.New(loc.formatters())
+		if strings.HasSuffix(name, ".css") {
+			parsed.SetDelims("${", "}")
+		}
+		if err := parsed.Parse(body); err != nil {
+			return nil, fmt.Errorf("%s: block %q: %s", file, block, err)
+		}
+		t.Blocks[block] = parsed
+	}
+	templates[loc.Name+":"+name] = t
+	return t, nil
+}
+
+// lookupTemplate returns a template parsed for a locale.  On the development
+// server, it is reloaded if its file has changed, or if one has been added to
+// or removed from the theme directory.
+func lookupTemplate(loc *Locale, name string) (*namedTemplate, os.Error) {
+	t, ok := templates[loc.Name+":"+name]
+	if !ok {
+		return loadTemplate(loc, name)
+	}
+	if devAppServer {
+		if file, mtime, err := templateFile(name); err != nil || file != t.Path || mtime != t.Mtime {
+			return loadTemplate(loc, name)
+		}
+	}
+	return t, nil
+}
+
+// executeTemplate writes a template without blocks, such as the widget or
+// the CSS.
+func executeTemplate(w io.Writer, loc *Locale, name string, data interface{}) os.Error {
+	t, err := lookupTemplate(loc, name)
+	if err != nil {
+		return err
+	}
+	block, ok := t.Blocks[""]
+	if !ok {
+		return fmt.Errorf("%s: is a page, with blocks", t.Path)
+	}
+	return block.Execute(w, data)
+}
+
+// A pageLayout is what the layout is rendered with.
+type pageLayout struct {
+	Title string
+	Head  string
+	Body  string
+
+	// Page is the data the page was rendered with, for its {CSS} and
+	// {Header}.
+	Page interface{}
+}
+
+// renderPage writes a page: its blocks, rendered with data, inside the
+// layout.
+func renderPage(w io.Writer, loc *Locale, name string, data interface{}) os.Error {
+	t, err := lookupTemplate(loc, name)
+	if err != nil {
+		return err
+	}
+
+	blocks := make(map[string]string)
+	for block, parsed := range t.Blocks {
+		buf := bytes.NewBuffer(nil)
+		if err := parsed.Execute(buf, data); err != nil {
+			return fmt.Errorf("%s: block %q: %s", t.Path, block, err)
+		}
+		blocks[block] = strings.TrimRight(buf.String(), "\n")
+	}
+
+	return executeTemplate(w, loc, layoutTemplate, &pageLayout{
+		Title: strings.TrimSpace(blocks["title"]),
+		Head:  blocks["head"],
+		Body:  blocks["body"],
+		Page:  data,
+	})
+}
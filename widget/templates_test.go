@@ -0,0 +1,28 @@
+package widget
+
+import (
+	"testing"
+)
+
+// Tests run in the package directory, next to the app's templates.
+func init() {
+	templateDir = "../templates"
+	themeDir = "../theme"
+}
+
+func TestTemplatesParse(t *testing.T) {
+	names, err := templateNames("")
+	if err != nil {
+		t.Fatalf("templateNames: %s", err)
+	}
+	if len(names) == 0 {
+		t.Fatalf("no templates found in %s", templateDir)
+	}
+	for _, loc := range locales {
+		for _, name := range names {
+			if _, err := loadTemplate(loc, name); err != nil {
+				t.Errorf("%s: %s", loc.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package widget
+
+import (
+	"time"
+
+	"appengine/datastore"
+)
+
+// Times are written as ISO-8601 in UTC by isotime, as the datetime of <time>
+// elements, and formatted by the browser.  Pages show them in the time zone
+// chosen in the viewer's profile, or in the browser's own if they haven't
+// chosen one, and embedded widgets show how long ago they were along with the
+// full time in the embedding page's time zone.  Without scripts, the text of
+// the element shows, which is the time in UTC.
+//
+// Emails and feeds are read without a browser to format them, so their times
+// are written by timeIn.  The time package can't load the rules of a time
+// zone, so they are only in the time zone from the recipient's profile if it
+// has a fixed offset from UTC, and are in UTC otherwise.
+
+// timeZones are the time zones which can be chosen in a profile.  They are
+// names from the tz database, which browsers know the rules for.
+var timeZones = []string{
+	"UTC",
+	"Pacific/Honolulu",
+	"America/Anchorage",
+	"America/Los_Angeles",
+	"America/Denver",
+	"America/Chicago",
+	"America/New_York",
+	"America/Halifax",
+	"America/Sao_Paulo",
+	"Atlantic/Azores",
+	"Europe/London",
+	"Europe/Berlin",
+	"Europe/Paris",
+	"Europe/Helsinki",
+	"Europe/Moscow",
+	"Africa/Cairo",
+	"Africa/Johannesburg",
+	"Asia/Dubai",
+	"Asia/Kolkata",
+	"Asia/Bangkok",
+	"Asia/Shanghai",
+	"Asia/Singapore",
+	"Asia/Tokyo",
+	"Australia/Perth",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+}
+
+// fixedZones are the time zones which can be chosen in a profile and don't
+// observe daylight saving time, with their abbreviations and offsets from UTC
+// in seconds.
+var fixedZones = map[string]struct {
+	Abbrev string
+	Offset int
+}{
+	"UTC":                 {"UTC", 0},
+	"Pacific/Honolulu":    {"HST", -10 * 3600},
+	"America/Sao_Paulo":   {"BRT", -3 * 3600},
+	"Africa/Johannesburg": {"SAST", 2 * 3600},
+	"Europe/Moscow":       {"MSK", 3 * 3600},
+	"Asia/Dubai":          {"GST", 4 * 3600},
+	"Asia/Kolkata":        {"IST", 5*3600 + 1800},
+	"Asia/Bangkok":        {"ICT", 7 * 3600},
+	"Asia/Shanghai":       {"CST", 8 * 3600},
+	"Asia/Singapore":      {"SGT", 8 * 3600},
+	"Australia/Perth":     {"AWST", 8 * 3600},
+	"Asia/Tokyo":          {"JST", 9 * 3600},
+}
+
+// timeLayout is how times are written for people to read.
+const timeLayout = "Jan 2, 2006 15:04 MST"
+
+// timeIn returns t as text in the given time zone from a profile, or in UTC if
+// the zone's offset isn't fixed.
+func timeIn(t datastore.Time, zone string) string {
+	sec := int64(t) / 1e6
+	z, ok := fixedZones[zone]
+	if !ok {
+		return time.SecondsToUTC(sec).Format(timeLayout)
+	}
+	local := time.SecondsToUTC(sec + int64(z.Offset))
+	local.ZoneOffset, local.Zone = z.Offset, z.Abbrev
+	return local.Format(timeLayout)
+}
+
+// validTimeZone returns true if zone can be chosen in a profile.  The empty
+// string means the browser's time zone.
+func validTimeZone(zone string) bool {
+	if len(zone) == 0 {
+		return true
+	}
+	for _, z := range timeZones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeZoneOptions returns the time zones to choose from, for a select.
+func (p *Profile) TimeZoneOptions() (opts []*categoryOption) {
+	for _, z := range timeZones {
+		opts = append(opts, &categoryOption{z, z == p.TimeZone})
+	}
+	return
+}
+
+// pageTimesScript formats the times on a page, in the time zone from the
+// header's data-timezone attribute and the language from its lang attribute.
+var pageTimesScript = `` +
+	`<script type="text/javascript">
+document.addEventListener("DOMContentLoaded", function() {
+	var header = document.getElementById("header");
+	var zone = header.getAttribute("data-timezone") || undefined;
+	var lang = header.getAttribute("lang") || undefined;
+	var times = document.querySelectorAll("time[datetime]");
+	for (var i = 0; i < times.length; i++) {
+		var date = new Date(times[i].getAttribute("datetime"));
+		if (isNaN(date.getTime())) continue;
+		var text;
+		try {
+			text = date.toLocaleString(lang, {timeZone: zone, timeZoneName: "short"});
+		} catch (e) {
+			text = date.toLocaleString(lang);
+		}
+		times[i].title = times[i].textContent;
+		times[i].textContent = text;
+	}
+}, false);
+</script>
+`
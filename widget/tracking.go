@@ -14,6 +14,11 @@ type Countable struct {
 	Widget *datastore.Key
 	Hash   string
 	Time   datastore.Time
+
+	// Optional details of a commit, as given to the commit hook
+	Rev     string
+	Author  string
+	Message string
 }
 
 func NewCountable(ctx appengine.Context, name, widgetid, hash string) *Countable {
@@ -39,6 +44,10 @@ func (c *Countable) Delete() (err os.Error) {
 	return
 }
 
+func (c *Countable) Date() string {
+	return timestr(c.Time)
+}
+
 func (c *Countable) Cache() (err os.Error) {
 	// TODO
 	return
@@ -61,6 +70,26 @@ func LoadCountable(ctx appengine.Context, name, widgetid string) (cs []*Countabl
 	return
 }
 
+// LoadRecentCountable returns the most recent countables of the given type
+// for a widget, newest first.
+func LoadRecentCountable(ctx appengine.Context, name, widgetid string, limit int) (cs []*Countable, err os.Error) {
+	parent := datastore.NewKey("Widget", strings.ToUpper(widgetid), 0, nil)
+
+	query := datastore.NewQuery(name)
+	query.Filter("Widget =", parent)
+	query.Order("-Time")
+	query.Limit(limit)
+
+	var k []*datastore.Key
+	k, err = query.GetAll(ctx, &cs)
+	for i, c := range cs {
+		c.ctx = ctx
+		c.key = k[i]
+	}
+
+	return
+}
+
 func LoadAllCountable(ctx appengine.Context, name string) (cs []*Countable, err os.Error) {
 	query := datastore.NewQuery(name)
 	query.Order("-Time")
@@ -74,3 +103,77 @@ func LoadAllCountable(ctx appengine.Context, name string) (cs []*Countable, err
 
 	return
 }
+
+// A Rollup totals the countables of one kind which have been expired for a
+// widget, so that they still count toward its totals.
+type Rollup struct {
+	Widget *datastore.Key
+	Kind   string
+	Count  int
+	First  datastore.Time
+	Last   datastore.Time
+}
+
+func rollupKey(widget *datastore.Key, kind string) *datastore.Key {
+	return datastore.NewKey("Rollup", kind, 0, widget)
+}
+
+// LoadRollup returns the rollup of the given kind for the widget.  If nothing
+// has been rolled up yet, an empty rollup is returned.
+func LoadRollup(ctx appengine.Context, widget *datastore.Key, kind string) (rollup *Rollup, err os.Error) {
+	rollup = &Rollup{
+		Widget: widget,
+		Kind:   kind,
+	}
+	err = datastore.Get(ctx, rollupKey(widget, kind), rollup)
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return
+}
+
+// expireBatch is the largest number of countables expired in one transaction.
+const expireBatch = 200
+
+// ExpireCountables adds countables of the given kind from before cutoff to
+// the widget's rollup and deletes them.  It returns the number expired.
+func ExpireCountables(ctx appengine.Context, widget *datastore.Key, kind string, cutoff datastore.Time) (expired int, err os.Error) {
+	err = datastore.RunInTransaction(ctx, func(tc appengine.Context) os.Error {
+		query := datastore.NewQuery(kind)
+		query.Ancestor(widget)
+		query.Filter("Time <", cutoff)
+		query.Order("Time")
+		query.Limit(expireBatch)
+
+		var old []*Countable
+		keys, err := query.GetAll(tc, &old)
+		if err != nil {
+			return err
+		}
+		if len(old) == 0 {
+			return nil
+		}
+
+		rollup, err := LoadRollup(tc, widget, kind)
+		if err != nil {
+			return err
+		}
+		if rollup.First == 0 || old[0].Time < rollup.First {
+			rollup.First = old[0].Time
+		}
+		if last := old[len(old)-1].Time; last > rollup.Last {
+			rollup.Last = last
+		}
+		rollup.Count += len(old)
+
+		if _, err := datastore.Put(tc, rollupKey(widget, kind), rollup); err != nil {
+			return err
+		}
+		if err := datastore.DeleteMulti(tc, keys); err != nil {
+			return err
+		}
+		expired = len(old)
+		return nil
+	}, nil)
+	return
+}
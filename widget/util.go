@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"time"
+	"utf8"
 
 	"appengine/datastore"
 )
@@ -12,8 +13,15 @@ func now() datastore.Time {
 	return datastore.Time(time.Nanoseconds()/1e3)
 }
 
+// timestr returns t as text in UTC, e.g. "Jun 1, 2011 15:04 UTC".
 func timestr(t datastore.Time) string {
-	return time.SecondsToLocalTime(int64(t)/1e6).String()
+	return timeIn(t, "UTC")
+}
+
+// isotime returns t as ISO-8601 in UTC, e.g. "2011-06-01T15:04:05Z", for the
+// datetime of a <time> element; see timezone.go.
+func isotime(t datastore.Time) string {
+	return time.SecondsToUTC(int64(t)/1e6).Format(time.RFC3339)
 }
 
 func Hashf(format string, args ...interface{}) string {
@@ -21,3 +29,19 @@ func Hashf(format string, args ...interface{}) string {
 	fmt.Fprintf(sum, format, args...)
 	return fmt.Sprintf("%X", sum.Sum())
 }
+
+// time2date returns a short date, suitable for labels.
+func time2date(t datastore.Time) string {
+	return time.SecondsToUTC(int64(t) / 1e6).Format("Jan 2")
+}
+
+// truncate shortens s to at most n bytes, without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
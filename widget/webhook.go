@@ -0,0 +1,406 @@
+package widget
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"http"
+	"json"
+	"os"
+	"strconv"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/taskqueue"
+	"appengine/urlfetch"
+)
+
+// Owners can register webhooks, which are sent a JSON description of events
+// on their widget.  Each delivery is a task on the webhook queue, which
+// retries failed deliveries with backoff (see queue.yaml), and every attempt
+// is recorded as a Delivery for the owner to see on the project page.
+//
+// The body of each delivery is signed with the webhook's secret: the
+// X-GoWidget-Signature header is "sha1=" followed by the hex HMAC-SHA1 of the
+// body.
+
+// A webhookEvent is an event which webhooks can subscribe to.
+type webhookEvent struct {
+	Name        string
+	Description string
+}
+
+var webhookEvents = []*webhookEvent{
+	{"score", "The score changed"},
+	{"broken", "Someone reported that it won't build"},
+	{"buildfail", "A build failed"},
+	{"buildhead", "The first successful build since the last commit"},
+}
+
+func validWebhookEvent(name string) bool {
+	for _, event := range webhookEvents {
+		if event.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// A Webhook is a URL which is sent a widget's events.  Webhooks are children
+// of their widget.
+type Webhook struct {
+	key *datastore.Key
+
+	URL     string
+	Secret  string
+	Events  []string
+	Created datastore.Time
+}
+
+const maxWebhooks = 5
+
+func (h *Webhook) KeyID() string {
+	return h.key.Encode()
+}
+
+func (h *Webhook) EventList() string {
+	return strings.Join(h.Events, ", ")
+}
+
+func (h *Webhook) Wants(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature of a delivery body.
+func (h *Webhook) Sign(body []byte) string {
+	mac := hmac.NewSHA1([]byte(h.Secret))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum())
+}
+
+// LoadWebhooks returns the webhooks registered for a widget.
+func LoadWebhooks(ctx appengine.Context, widget *datastore.Key) (hooks []*Webhook, err os.Error) {
+	query := datastore.NewQuery("Webhook")
+	query.Ancestor(widget)
+
+	var k []*datastore.Key
+	k, err = query.GetAll(ctx, &hooks)
+	for i, h := range hooks {
+		h.key = k[i]
+	}
+	return
+}
+
+// Webhooks returns the webhooks registered for the widget, for the owner's
+// page.
+func (w *Widget) Webhooks() []*Webhook {
+	hooks, err := LoadWebhooks(w.ctx, w.key)
+	if err != nil {
+		w.ctx.Warningf("Widget %s: webhooks: %s", w.ID, err)
+	}
+	return hooks
+}
+
+// WebhookEvents returns the events webhooks can subscribe to, for the owner's
+// page.
+func (w *Widget) WebhookEvents() []*webhookEvent {
+	return webhookEvents
+}
+
+// A Delivery records an attempt to send an event to a webhook.  Deliveries
+// are children of the widget.
+type Delivery struct {
+	Webhook *datastore.Key
+	URL     string
+	Event   string
+	Attempt int64
+	Status  int64
+	Error   string
+	Time    datastore.Time
+}
+
+const recentDeliveries = 20
+
+func (d *Delivery) Date() string {
+	return timestr(d.Time)
+}
+
+func (d *Delivery) OK() bool {
+	return len(d.Error) == 0
+}
+
+// LoadDeliveries returns the most recent deliveries for a widget, newest
+// first.
+func LoadDeliveries(ctx appengine.Context, widget *datastore.Key, limit int) (ds []*Delivery, err os.Error) {
+	query := datastore.NewQuery("Delivery")
+	query.Ancestor(widget)
+	query.Order("-Time")
+	query.Limit(limit)
+
+	_, err = query.GetAll(ctx, &ds)
+	return
+}
+
+// deliveryRetention is how long deliveries are kept.
+const deliveryRetention = datastore.Time(30 * 24 * 60 * 60 * 1e6)
+
+// ExpireDeliveries deletes a batch of the widget's deliveries from before
+// cutoff, and returns how many were deleted.
+func ExpireDeliveries(ctx appengine.Context, widget *datastore.Key, cutoff datastore.Time) (int, os.Error) {
+	query := datastore.NewQuery("Delivery")
+	query.Ancestor(widget)
+	query.Filter("Time <", cutoff)
+	query.KeysOnly()
+	query.Limit(expireBatch)
+
+	keys, err := query.GetAll(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := datastore.DeleteMulti(ctx, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// FireEvent queues the delivery of an event on a widget to each of its
+// webhooks which wants it.  Data holds the details of the event.
+func FireEvent(ctx appengine.Context, widget *Widget, event string, data map[string]interface{}) os.Error {
+	hooks, err := LoadWebhooks(ctx, widget.key)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	for _, h := range hooks {
+		if !h.Wants(event) {
+			continue
+		}
+
+		if payload == nil {
+			payload, err = json.Marshal(map[string]interface{}{
+				"event": event,
+				"time":  atomTime(now()),
+				"widget": map[string]interface{}{
+					"id":    widget.ID,
+					"name":  widget.Name,
+					"url":   siteURL + "/p/" + widget.ID,
+					"score": widget.CachedScore,
+				},
+				"data": data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		task := taskqueue.NewPOSTTask("/task/webhook", http.Values{
+			"hook":    {h.KeyID()},
+			"event":   {event},
+			"payload": {string(payload)},
+		})
+		if _, err := taskqueue.Add(ctx, task, "webhook"); err != nil {
+			return err
+		}
+		ctx.Debugf("Webhook: %s for %s queued to %s", event, widget.ID, h.URL)
+	}
+	return nil
+}
+
+// fireScoreChanged fires the score event if the widget's score has changed
+// from old since it was populated.
+func fireScoreChanged(ctx appengine.Context, widget *Widget, old int64) {
+	if widget.CachedScore == old {
+		return
+	}
+	err := FireEvent(ctx, widget, "score", map[string]interface{}{
+		"old": old,
+		"new": widget.CachedScore,
+	})
+	if err != nil {
+		ctx.Errorf("Widget %s: score event: %s", widget.ID, err)
+	}
+}
+
+// firstBuildAtHead reports whether there have been no builds of the widget
+// since its last commit.
+func firstBuildAtHead(ctx appengine.Context, widget *Widget) (bool, os.Error) {
+	var head datastore.Time
+	commits, err := LoadRecentCountable(ctx, "Commit", widget.ID, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(commits) > 0 {
+		head = commits[0].Time
+	}
+
+	query := datastore.NewQuery("Build")
+	query.Filter("Widget =", widget.key)
+	query.Filter("Time >", head)
+	query.Limit(1)
+	builds, err := query.Count(ctx)
+	if err != nil {
+		return false, err
+	}
+	return builds == 0, nil
+}
+
+// taskWebhook delivers an event queued by FireEvent.  Failures are returned
+// as errors so that the task queue tries again later.
+func taskWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	key, err := datastore.DecodeKey(r.FormValue("hook"))
+	if err != nil {
+		http.Error(w, "Invalid webhook: "+err.String(), http.StatusBadRequest)
+		return
+	}
+
+	hook := new(Webhook)
+	if err := datastore.Get(ctx, key, hook); err == datastore.ErrNoSuchEntity {
+		// Removed since the event was queued
+		fmt.Fprintf(w, "Webhook removed")
+		return
+	} else if err != nil {
+		http.Error(w, err.String(), http.StatusInternalServerError)
+		return
+	}
+	hook.key = key
+
+	retries, _ := strconv.Atoi64(r.Header.Get("X-AppEngine-TaskRetryCount"))
+	delivery := &Delivery{
+		Webhook: key,
+		URL:     hook.URL,
+		Event:   r.FormValue("event"),
+		Attempt: retries + 1,
+		Time:    now(),
+	}
+
+	status, err := deliver(ctx, hook, delivery.Event, []byte(r.FormValue("payload")))
+	delivery.Status = int64(status)
+	if err != nil {
+		delivery.Error = err.String()
+	}
+
+	if _, err := datastore.Put(ctx, datastore.NewIncompleteKey("Delivery", key.Parent()), delivery); err != nil {
+		ctx.Errorf("Webhook: record delivery: %s", err)
+	}
+
+	if len(delivery.Error) > 0 {
+		ctx.Warningf("Webhook: %s to %s (attempt %d): %s", delivery.Event, hook.URL, delivery.Attempt, delivery.Error)
+		http.Error(w, delivery.Error, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Delivered %s to %s", delivery.Event, hook.URL)
+}
+
+// deliver posts a payload to a webhook, and returns the status code of the
+// response.
+func deliver(ctx appengine.Context, hook *Webhook, event string, payload []byte) (int, os.Error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Go-Widget-Webhook")
+	req.Header.Set("X-GoWidget-Event", event)
+	req.Header.Set("X-GoWidget-Signature", hook.Sign(payload))
+
+	resp, err := urlfetch.Client(ctx).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// editWebhooks adds or removes one of a widget's webhooks.
+func editWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/", -1)
+	if len(path) != 3 {
+		http.Error(w, "/widget/webhooks/{widget} - missing required path segment", http.StatusBadRequest)
+		return
+	}
+
+	widgethash := path[2]
+	if len(widgethash) != 32 {
+		http.Error(w, "Invalid widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+
+	widget, err := LoadWidget(ctx, widgethash)
+	if err != nil {
+		http.Error(w, "Unknown widget id: "+widgethash, http.StatusBadRequest)
+		return
+	}
+	if !widget.CanEdit() {
+		http.Error(w, "Only the owner can change this widget", http.StatusForbidden)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "add":
+		url := strings.TrimSpace(r.FormValue("url"))
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			http.Error(w, "Webhook URLs must be http or https", http.StatusBadRequest)
+			return
+		}
+		if len(widget.Webhooks()) >= maxWebhooks {
+			http.Error(w, fmt.Sprintf("At most %d webhooks are allowed", maxWebhooks), http.StatusBadRequest)
+			return
+		}
+
+		hook := &Webhook{
+			URL:     url,
+			Created: now(),
+		}
+		for _, event := range r.Form["event"] {
+			if !validWebhookEvent(event) {
+				http.Error(w, "Unknown event: "+event, http.StatusBadRequest)
+				return
+			}
+			hook.Events = append(hook.Events, event)
+		}
+		if len(hook.Events) == 0 {
+			http.Error(w, "Choose at least one event", http.StatusBadRequest)
+			return
+		}
+		if hook.Secret, err = newToken(); err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := datastore.Put(ctx, datastore.NewIncompleteKey("Webhook", widget.key), hook); err != nil {
+			http.Error(w, "Error comitting: "+err.String(), http.StatusInternalServerError)
+			return
+		}
+	case "delete":
+		key, err := datastore.DecodeKey(r.FormValue("hook"))
+		if err != nil || !key.Parent().Eq(widget.key) {
+			http.Error(w, "Invalid webhook", http.StatusBadRequest)
+			return
+		}
+		if err := datastore.Delete(ctx, key); err != nil {
+			http.Error(w, err.String(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Unknown action: "+r.FormValue("action"), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/widget/list", http.StatusFound)
+}
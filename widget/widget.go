@@ -6,11 +6,10 @@ import (
 	"html"
 	"io"
 	"os"
-	"template"
+	"strings"
 
 	"appengine"
 	"appengine/user"
-	"appengine/memcache"
 	"appengine/datastore"
 )
 
@@ -20,18 +19,15 @@ type Widget struct {
 
 	populated bool
 	dirty bool
+	stale bool
+	rated bool
+	err os.Error
 
-	rating int
-	broken int
+	override embedOverride
+	locale *Locale // see i18n.go
+	zone   string  // for CompileDate and CheckinDate, see timezone.go
 
-	builds int
-	buildWeek int
-	buildHead int
-	buildLast datastore.Time
-
-	commits int
-	commitWeek int
-	commitLast datastore.Time
+	stats Stats
 
 	Name  string
 	ID    string
@@ -41,48 +37,127 @@ type Widget struct {
 	BugURL    string
 	SourceURL string
 
+	Summary      string
+	Description  []byte // Markdown
+	EmbedSummary bool
+
+	// Appearance of the embedded widget, see appearance.go and layout.go
+	Theme       string
+	ThemeColors string
+	Layout      string
+	Sections    []string
+
+	Created  datastore.Time
+	Category string
+	Tags     []string
+
 	// For leaderboard
 	CachedScore int64
 	CachedRating int64
+	CachedBuildWeek int64
+	CachedCommitLast datastore.Time
+
+	// ScoreTiers holds every score from 1 up to CachedScore, so that the
+	// leader board can filter by a minimum score with an equality filter and
+	// still be sorted by something else.
+	ScoreTiers []int64
+
+	// For search, see searchTokens
+	SearchTokens []string
 }
 
 func (w *Widget) CompileDate() string {
 	if !w.populated { w.populate() }
-	if w.buildLast == 0 {
-		return "never"
+	if w.stats.BuildLast == 0 {
+		return w.loc().Text("Never")
 	}
-	return timestr(w.buildLast)
+	return timeIn(w.stats.BuildLast, w.zone)
 }
 
 func (w *Widget) CheckinDate() string {
 	if !w.populated { w.populate() }
-	if w.commitLast == 0 {
-		return "never"
+	if w.stats.CommitLast == 0 {
+		return w.loc().Text("Never")
+	}
+	return timeIn(w.stats.CommitLast, w.zone)
+}
+
+// CompileTime returns the time of the last build for a <time> element, or
+// "" if there hasn't been one.
+func (w *Widget) CompileTime() string {
+	if !w.populated { w.populate() }
+	if w.stats.BuildLast == 0 {
+		return ""
 	}
-	return timestr(w.commitLast)
+	return isotime(w.stats.BuildLast)
+}
+
+// CheckinTime returns the time of the last commit for a <time> element, or
+// "" if there hasn't been one.
+func (w *Widget) CheckinTime() string {
+	if !w.populated { w.populate() }
+	if w.stats.CommitLast == 0 {
+		return ""
+	}
+	return isotime(w.stats.CommitLast)
+}
+
+// Lang returns the language the widget is shown in, for its lang attribute.
+func (w *Widget) Lang() string {
+	return w.loc().Name
 }
 
 func (w *Widget) CompileElapsed() string {
 	if !w.populated { w.populate() }
-	if w.buildLast == 0 {
-		return "never"
+	if w.stats.BuildLast == 0 {
+		return w.loc().Text("Never")
 	}
-	elapsedHours := int64(now()-w.buildLast) / 1e6 / 60 / 60
-	elapsedHours, elapsedDays := elapsedHours%24, elapsedHours/24
-	return fmt.Sprintf("%dd %dh", elapsedDays, elapsedHours)
+	return w.loc().Ago(int64(now()-w.stats.BuildLast) / 1e6)
 }
 
 func (w *Widget) CheckinElapsed() string {
 	if !w.populated { w.populate() }
-	if w.commitLast == 0 {
-		return "never"
+	if w.stats.CommitLast == 0 {
+		return w.loc().Text("Never")
+	}
+	return w.loc().Ago(int64(now()-w.stats.CommitLast) / 1e6)
+}
+
+// maxSummary is the longest summary, in characters.
+const maxSummary = 200
+
+// cleanSummary returns a summary suitable for storing: a single line of no
+// more than maxSummary characters.
+func cleanSummary(summary string) string {
+	summary = strings.Join(strings.Fields(summary), " ")
+	if runes := []int(summary); len(runes) > maxSummary {
+		summary = string(runes[:maxSummary-3]) + "..."
 	}
-	elapsedHours := int64(now()-w.commitLast) / 1e6 / 60 / 60
-	elapsedHours, elapsedDays := elapsedHours%24, elapsedHours/24
-	return fmt.Sprintf("%dd %dh", elapsedDays, elapsedHours)
+	return summary
+}
+
+// SummaryHTML returns the summary, escaped for HTML.
+func (w *Widget) SummaryHTML() string {
+	return escapeHTML(w.Summary)
+}
+
+// DescriptionHTML returns the description rendered from Markdown.
+func (w *Widget) DescriptionHTML() string {
+	return Markdown(string(w.Description))
+}
+
+func (w *Widget) DescriptionText() string {
+	return string(w.Description)
+}
+
+// CanEdit returns true if the current user is allowed to change the widget.
+func (w *Widget) CanEdit() bool {
+	u := user.Current(w.ctx)
+	return u != nil && (u.Email == w.Owner || user.IsAdmin(w.ctx))
 }
 
 func (w *Widget) Commit() (err os.Error) {
+	w.SearchTokens = w.searchTokens()
 	w.key, err = datastore.Put(w.ctx, w.key, w)
 	return
 }
@@ -103,6 +178,7 @@ func NewWidget(ctx appengine.Context, name string) *Widget {
 		Name:   name,
 		ID:     hash,
 		Owner:  u.Email,
+		Created: now(),
 		populated: true,
 	}
 }
@@ -119,10 +195,12 @@ func LoadWidget(ctx appengine.Context, id string) (widget *Widget, err os.Error)
 }
 
 func LoadWidgets(ctx appengine.Context) (widgets []*Widget, err os.Error) {
-	u := user.Current(ctx)
+	return LoadWidgetsByOwner(ctx, user.Current(ctx).Email)
+}
 
+func LoadWidgetsByOwner(ctx appengine.Context, email string) (widgets []*Widget, err os.Error) {
 	query := datastore.NewQuery("Widget")
-	query.Filter("Owner =", u.Email)
+	query.Filter("Owner =", email)
 	query.Order("Name")
 
 	var k []*datastore.Key
@@ -136,18 +214,7 @@ func LoadWidgets(ctx appengine.Context) (widgets []*Widget, err os.Error) {
 }
 
 func LoadTopWidgets(ctx appengine.Context) (widgets []*Widget, err os.Error) {
-	query := datastore.NewQuery("Widget")
-	query.Order("-CachedScore")
-	query.Order("-CachedRating")
-	query.Limit(50)
-
-	var k []*datastore.Key
-	k, err = query.GetAll(ctx, &widgets)
-	for i, w := range widgets {
-		w.ctx = ctx
-		w.key = k[i]
-	}
-
+	widgets, _, err = LoadLeaderBoard(ctx, &LeaderBoardQuery{})
 	return
 }
 
@@ -164,274 +231,173 @@ func LoadAllWidgets(ctx appengine.Context) (widgets []*Widget, err os.Error) {
 	return
 }
 
-func (w *Widget) Score() (score int) {
-	if !w.populated { w.populate() }
-	if w.rating >= 5 {
-		score++
-	}
-	if w.broken <= 1 {
-		score++
-	}
-	if w.builds >= 50 {
-		score++
+// A Criterion is one of the things a widget is scored on.
+type Criterion struct {
+	Name    string
+	Current string
+	Pass    bool
+}
+
+func (c *Criterion) Status() string {
+	if c.Pass {
+		return "pass"
 	}
-	if w.buildHead >= 5 {
-		score++
+	return "fail"
+}
+
+// ScoreCriteria returns the criteria which make up the widget's score.
+func (w *Widget) ScoreCriteria() []*Criterion {
+	if !w.populated { w.populate() }
+	urls := 0
+	for _, url := range []string{w.HomeURL, w.SourceURL, w.BugURL} {
+		if len(url) > 15 {
+			urls++
+		}
 	}
-	if len(w.BugURL) > 15 && len(w.SourceURL) > 15 && len(w.HomeURL) > 15 {
-		score++
+	l := w.loc()
+	return []*Criterion{
+		{l.Text("CriterionRating"), fmt.Sprint(w.stats.Rating), w.stats.Rating >= 5},
+		{l.Text("CriterionBuilds"), fmt.Sprint(w.stats.Builds), w.stats.Builds >= 50},
+		// TODO(kevlar): since Go release
+		{l.Text("CriterionBuildsHead"), fmt.Sprint(w.stats.BuildHead), w.stats.BuildHead >= 5},
+		{l.Text("CriterionBroken"), fmt.Sprint(w.stats.Broken), w.stats.Broken <= 1},
+		{l.Text("CriterionURLs"), fmt.Sprintf(l.Text("CriterionURLsOf"), urls), urls == 3},
 	}
-	return
 }
 
-func (w *Widget) populate() {
-	defer func() {
-		if err := recover(); err != nil {
-			w.ctx.Debugf("populate(): %s", err)
-		}
-	}()
-
-	chk := func(err os.Error) bool {
-		switch err {
-		case nil:
-			return true
-		case datastore.Done:
-			return false
+func (w *Widget) Score() (score int) {
+	for _, c := range w.ScoreCriteria() {
+		if c.Pass {
+			score++
 		}
-		panic(err)
 	}
+	return
+}
 
-	if w.populated { return }
+// populate fills in the widget's stats, from the cache if possible.  If they
+// can't be computed, the last known stats are used instead (and Stale reports
+// true) and the error is returned; it is also remembered for Unavailable.
+func (w *Widget) populate() os.Error {
+	if w.populated { return w.err }
 
-	cache := make(map[string]interface{})
 	if !w.dirty {
-		if _, err := memcache.Gob.Get(w.ctx, "widget:"+w.ID, &cache); err != memcache.ErrCacheMiss {
-			chk(err)
-
+		if stats := LoadStats(w.ctx, w.ID); stats != nil {
 			w.ctx.Debugf("Cache hit: %s", w.ID)
-			w.ctx.Debugf(" - %v", cache)
-
-			w.rating = cache["Rating"].(int)
-			w.broken = cache["Broken"].(int)
-
-			w.builds = cache["Builds"].(int)
-			w.buildWeek = cache["BuildWeek"].(int)
-			w.buildHead = cache["BuildHead"].(int)
-			w.buildLast = datastore.Time(cache["BuildLast"].(int64))
-
-			w.commits = cache["Commits"].(int)
-			w.commitWeek = cache["CommitWeek"].(int)
-			w.commitLast = datastore.Time(cache["CommitLast"].(int64))
+			w.ctx.Debugf(" - %+v", stats)
 
+			w.stats = *stats
 			w.populated = true
-			return
+			return nil
 		}
 	} else {
 		w.ctx.Debugf("Cache: Widget %s is dirty", w.ID)
 	}
 
-	lastweek := now() - datastore.SecondsToTime(7*24*60*60)
-
-	var query *datastore.Query
-	var items []*Countable
-	var err os.Error
-
-	// Broken
-	query = datastore.NewQuery("Broken")
-	query.Filter("Widget =", w.key)
-	w.broken, err = query.Count(w.ctx)
-	chk(err)
-	w.ctx.Debugf("Widget %s has %d broken", w.ID, w.broken)
-
-	// Rating
-	query = datastore.NewQuery("Rating")
-	query.Filter("Widget =", w.key)
-	w.rating, err = query.Count(w.ctx)
-	chk(err)
-	w.ctx.Debugf("Widget %s has %d rating", w.ID, w.rating)
-
-	// Get Commits
-	query = datastore.NewQuery("Commit")
-	query.Filter("Widget =", w.key)
-	query.Order("-Time")
-	w.commits, err = query.Count(w.ctx)
-	chk(err)
-	w.ctx.Debugf("Widget %s has %d commits", w.ID, w.commits)
-	query.Filter("Time >", lastweek)
-	w.commitWeek, err = query.Count(w.ctx)
-	chk(err)
-	w.ctx.Debugf("Widget %s has %d commits this week", w.ID, w.commitWeek)
-
-	query = datastore.NewQuery("Commit")
-	query.Filter("Widget =", w.key)
-	query.Order("-Time")
-	query.Limit(1)
-	_, err = query.GetAll(w.ctx, &items)
-	chk(err)
-	if len(items) > 0 {
-		w.commitLast = items[0].Time
-	}
-	w.ctx.Debugf("Widget %s was committed %d", w.ID, w.commitLast)
-
-	// Get builds
-	query = datastore.NewQuery("Build")
-	query.Filter("Widget =", w.key)
-	query.Order("-Time")
-	w.builds, err = query.Count(w.ctx)
-	chk(err)
-	w.ctx.Debugf("Widget %s has %d builds", w.ID, w.builds)
-	query.Filter("Time >", lastweek)
-	w.buildWeek, err = query.Count(w.ctx)
-	chk(err)
-	w.ctx.Debugf("Widget %s has %d builds this week", w.ID, w.buildWeek)
-
-	query = datastore.NewQuery("Build")
-	query.Filter("Widget =", w.key)
-	query.Order("-Time")
-	query.Limit(1)
-	_, err = query.GetAll(w.ctx, &items)
-	chk(err)
-	if len(items) > 0 {
-		w.buildLast = items[0].Time
-	}
-	if w.commitLast > 0 {
-		query.Filter("Time >", w.commitLast)
-		w.buildHead, err = query.Count(w.ctx)
-		chk(err)
-	} else {
-		w.buildHead = 0
-		w.ctx.Debugf("Widget %s has no commits", w.ID)
-	}
-	w.ctx.Debugf("Widget %s has %d builds at HEAD", w.ID, w.buildHead)
-
 	w.populated = true
-	w.ctx.Debugf("Widget %s populated", w.ID)
 
-	w.CachedRating = int64(w.rating)
+	stats, err := computeStats(w.ctx, w.key)
+	if err != nil {
+		w.err = err
+		w.ctx.Errorf("Widget %s: populate: %s", w.ID, err)
+		countMetric(w.ctx, "stats.populate_error")
+
+		if last := LoadLastStats(w.ctx, w.ID); last != nil {
+			w.ctx.Infof("Widget %s: serving stale stats", w.ID)
+			countMetric(w.ctx, "stats.stale")
+			w.stats = *last
+			w.stale = true
+		}
+		return err
+	}
+	w.stats = *stats
+	w.ctx.Debugf("Widget %s populated: %+v", w.ID, w.stats)
+
+	w.CachedRating = int64(w.stats.Rating)
 	w.CachedScore = int64(w.Score())
+	w.CachedBuildWeek = int64(w.stats.BuildWeek)
+	w.CachedCommitLast = w.stats.CommitLast
+	w.ScoreTiers = nil
+	for tier := int64(1); tier <= w.CachedScore; tier++ {
+		w.ScoreTiers = append(w.ScoreTiers, tier)
+	}
 
-	cache["Broken"] = w.broken
-	cache["Rating"] = w.rating
-	cache["Builds"] = w.builds
-	cache["BuildWeek"] = w.buildWeek
-	cache["BuildHead"] = w.buildHead
-	cache["BuildLast"] = int64(w.buildLast)
-	cache["Commits"] = w.commits
-	cache["CommitWeek"] = w.commitWeek
-	cache["CommitLast"] = int64(w.commitLast)
-	err = memcache.Gob.Set(w.ctx, &memcache.Item{
-		Key: "widget:"+w.ID,
-		Expiration: 12*60*60, // 12 hours (because we do some date calculation in here)
-		Object: cache,
-	})
-	chk(err)
+	if err := SaveStats(w.ctx, w.ID, &w.stats); err != nil {
+		// The stats are still good, they just weren't cached
+		w.ctx.Warningf("Widget %s: cache: %s", w.ID, err)
+		return nil
+	}
 	w.ctx.Debugf("Cached: Widget %s", w.ID)
+	return nil
+}
+
+// Unavailable returns true if the widget's stats could not be loaded and
+// there were no previous stats to fall back on.
+func (w *Widget) Unavailable() bool {
+	if !w.populated { w.populate() }
+	return w.err != nil && !w.stale
+}
+
+// Stale returns true if the widget's stats could not be loaded and the last
+// known stats are being shown instead.
+func (w *Widget) Stale() bool {
+	if !w.populated { w.populate() }
+	return w.stale
 }
 
 func (w *Widget) Rating() int {
 	if !w.populated { w.populate() }
-	return w.rating
+	return w.stats.Rating
 }
 
 func (w *Widget) Broken() int {
 	if !w.populated { w.populate() }
-	return w.broken
+	return w.stats.Broken
 }
 
 func (w *Widget) CompileTotal() int {
 	if !w.populated { w.populate() }
-	return w.builds
+	return w.stats.Builds
 }
 
 func (w *Widget) CompileWeek() int {
 	if !w.populated { w.populate() }
-	return w.buildWeek
+	return w.stats.BuildWeek
 }
 
 func (w *Widget) CompileCheckin() int {
 	if !w.populated { w.populate() }
-	return w.buildHead
+	return w.stats.BuildHead
 }
 
 func (w *Widget) CheckinTotal() int {
 	if !w.populated { w.populate() }
-	return w.commits
+	return w.stats.Commits
 }
 
 func (w *Widget) CheckinWeek() int {
 	if !w.populated { w.populate() }
-	return w.commitWeek
-}
-
-var widgetTemplate = template.MustParse(``+
-	`<table class="gowidget">
-	<thead>
-		<tr>
-			<th colspan="3">
-				<a href="{HomeURL}">{Name}</a> - {Score}/5
-			</th>
-		</tr>
-	</thead>
-	<tfoot>
-		<tr>
-			<td colspan=3>
-				<a href="#" onclick="expand(this, 0);return false">Project</a>
-				-
-				<a href="#" onclick="expand(this, 1);return false">Builds</a>
-				-
-				Powered by <a href="http://go-widget.appspot.com/">Go-Widget</a>
-			</td>
-		</tr>
-	</tfoot>
-	<tbody>
-		<tr>
-			<td>
-				Rating: {Rating} (<a href="/hook/plusone/{ID}">+</a>)
-			</td>
-			<td>
-				<a href="/hook/wontbuild/{ID}">Broken</a> ({Broken})</span>
-			</td>
-		</tr>
-		<tr>
-			<td>
-				<a href="{SourceURL}">Source Code</a></span>
-			</td>
-			<td>
-				<a href="{BugURL}">Report Bug</a></span>
-			</td>
-		</tr>
-	</tbody>
-	<tbody style="display: none">
-		<tr>
-			<th></th>
-			<th>Build</th>
-			<th>Commit</th>
-		</tr>
-		<tr>
-			<th>Weekly</th>
-			<!--InstallWeek-->
-			<td>{CompileWeek}</td>
-			<td>{CheckinWeek}</td>
-		</tr>
-		<tr>
-			<th>Total</th>
-			<!--InstallTotal-->
-			<td>{CompileTotal}</td>
-			<td>{CheckinTotal}</td>
-		</tr>
-		<tr>
-			<th>Last</th>
-			<!--InstallElapsed-->
-			<td>{CompileElapsed}</td>
-			<td>{CheckinElapsed}</td>
-		</tr>
-	</tbody>
-</table>
-`, nil)
+	return w.stats.CommitWeek
+}
 
 func (w *Widget) Execute(out io.Writer) os.Error {
-	writeWidgetCSS(out)
-	return widgetTemplate.Execute(out, w)
+	writeWidgetCSS(out, w.currentTheme())
+	fmt.Fprint(out, widgetScript)
+	if err := w.executeBody(out); err != nil {
+		return err
+	}
+	fmt.Fprint(out, widgetTimesScript)
+	return nil
+}
+
+// executeBody writes the widget without its CSS and script, from the
+// template in templates/widget for its layout.  Widgets whose stats can't be
+// loaded at all are shown with unavailable.html instead.
+func (w *Widget) executeBody(out io.Writer) os.Error {
+	name := w.currentLayout()
+	if w.Unavailable() {
+		name = "unavailable"
+	}
+	return executeTemplate(out, w.loc(), "widget/"+name+".html", w)
 }
 
 // ExecuteString returns a string safe to embed in a single-quoted string.  If